@@ -1,18 +1,36 @@
 package main
 
 import (
+	"bytes"
 	"log"
 	"os"
 
 	"github.com/chaimleib/synth"
+	"github.com/chaimleib/synth/midi"
+	"github.com/chaimleib/synth/pcm"
 )
 
+const usage = `usage:
+  synth out.wav
+  synth render song.mid out.wav
+
+The output format is picked from the extension of the filepath: .aif, .aiff,
+.aifc, .au, .snd or .flac. Any other extension, such as .wav, gives WAV.`
+
 func main() {
-	if len(os.Args[1:]) != 1 {
-		log.Fatal("expected a filepath argument")
+	args := os.Args[1:]
+	switch {
+	case len(args) == 1:
+		saveExample(args[0])
+	case len(args) == 3 && args[0] == "render":
+		render(args[1], args[2])
+	default:
+		log.Fatal(usage)
 	}
-	fpath := os.Args[1]
+}
 
+// saveExample saves the example tones to fpath.
+func saveExample(fpath string) {
 	reader, enc, _, err := synth.ExampleTones(0)
 	if err != nil {
 		log.Fatal(err)
@@ -22,3 +40,26 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// render plays the Standard MIDI File at midiPath into an audio file at
+// fpath.
+func render(midiPath, fpath string) {
+	f, err := os.Open(midiPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	song, err := midi.Decode(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := pcm.New(48000, 2, 2)
+	buf, err := midi.Render(song, enc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := synth.Save(bytes.NewReader(buf.Bytes()), enc, fpath); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,278 @@
+// Package aiff allows reading and writing PCM or float audio data in AIFF and
+// AIFF-C files.
+package aiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+// AIFF holds the fields of the file header. Sources: Audio Interchange File
+// Format 1.3, and the AIFF-C draft of 1991.
+type AIFF struct {
+	// FORM chunk
+	FormID   [4]byte // value:"FORM"
+	FormSize uint32  // 4 + chunks, including their headers
+	FormType [4]byte // value:"AIFF" or "AIFC"
+
+	// FVER chunk, only in AIFF-C
+	FVERTimestamp uint32
+
+	// COMM chunk
+	CommSize        uint32
+	NumChannels     uint16
+	NumSampleFrames uint32
+	SampleSize      uint16  // bits per sample
+	SampleRate      float64 // stored as an 80-bit extended float
+	CompressionType [4]byte // only in AIFF-C
+	CompressionName string  // only in AIFF-C
+
+	// SSND chunk
+	SSNDSize  uint32 // 8 + size of the samples
+	Offset    uint32
+	BlockSize uint32
+	// SampledData has to be appended manually.
+}
+
+// Compression types, which select the sample encoding.
+const (
+	// CompressionNone is big-endian integer PCM, as in plain AIFF files.
+	CompressionNone = "NONE"
+	// CompressionSowt is little-endian integer PCM.
+	CompressionSowt = "sowt"
+	// CompressionFloat32 is big-endian 32-bit float.
+	CompressionFloat32 = "fl32"
+	// CompressionFloat64 is big-endian 64-bit float.
+	CompressionFloat64 = "fl64"
+)
+
+// fverAIFC is the AIFF-C version timestamp, from May 23, 1990.
+const fverAIFC = 0xA2805140
+
+// ErrTooLarge is returned when the data exceeds the 4 GiB FORM limit.
+var ErrTooLarge = errors.New("data exceeds the 4 GiB AIFF limit")
+
+// compressionNames are the human-readable names written for each
+// compression type.
+var compressionNames = map[string]string{
+	CompressionNone:    "not compressed",
+	CompressionSowt:    "little-endian",
+	CompressionFloat32: "32-bit floating point",
+	CompressionFloat64: "64-bit floating point",
+}
+
+type encoder struct {
+	Compression string
+	NbrChannels uint16
+	Frequency   uint32
+	ByteDepth   int
+
+	// AIFC selects AIFF-C output even for uncompressed samples. Compression
+	// types other than CompressionNone always use AIFF-C.
+	AIFC bool
+}
+
+// NewEncoder creates a new encoder, which describes the format of the audio
+// samples to be encoded. The samples are taken in the same little-endian
+// layout as a pcm.Buffer, with 8-bit samples unsigned.
+func NewEncoder(compression string, nbrChannels, byteDepth, frequency int) *encoder {
+	return &encoder{
+		Compression: compression,
+		NbrChannels: uint16(nbrChannels),
+		Frequency:   uint32(frequency),
+		ByteDepth:   byteDepth,
+	}
+}
+
+// NewEncoderFor creates a new encoder for samples produced by a pcm.Encoder,
+// picking the compression type which matches its sample format. G.711
+// formats map to the AIFF-C ulaw and alaw types, which NewWriter rejects as
+// unsupported.
+func NewEncoderFor(enc *pcm.Encoder) *encoder {
+	compression := CompressionNone
+	switch enc.Format {
+	case pcm.FormatFloat32:
+		compression = CompressionFloat32
+	case pcm.FormatFloat64:
+		compression = CompressionFloat64
+	case pcm.FormatMuLaw:
+		compression = "ulaw"
+	case pcm.FormatALaw:
+		compression = "alaw"
+	}
+	return NewEncoder(compression, enc.Channels, enc.Depth, enc.Rate)
+}
+
+// Encode takes an io.Reader and returns a buffer containing an AIFF file.
+func (e *encoder) Encode(r io.Reader) ([]byte, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	w, err := e.NewWriter(&out, int64(len(buf)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the audio samples.
+	if _, err = w.Write(buf); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// header returns the file header for the given number of bytes of samples.
+func (e *encoder) header(dataSize uint64) (*AIFF, error) {
+	a := new(AIFF)
+	copy(a.FormID[:], "FORM")
+	copy(a.FormType[:], "AIFF")
+
+	a.CommSize = 18
+	a.NumChannels = e.NbrChannels
+	a.SampleSize = 8 * uint16(e.ByteDepth)
+	a.SampleRate = float64(e.Frequency)
+
+	if e.AIFC || e.Compression != CompressionNone {
+		copy(a.FormType[:], "AIFC")
+		a.FVERTimestamp = fverAIFC
+		copy(a.CompressionType[:], e.Compression)
+		a.CompressionName = compressionNames[e.Compression]
+		a.CommSize += 4 + uint32(len(pstring(a.CompressionName)))
+	}
+
+	if err := a.setDataSize(dataSize); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// isAIFC reports whether the header is for an AIFF-C file.
+func (a *AIFF) isAIFC() bool {
+	return string(a.FormType[:]) == "AIFC"
+}
+
+// compression returns the compression type, which is always
+// CompressionNone for plain AIFF files.
+func (a *AIFF) compression() string {
+	if !a.isAIFC() {
+		return CompressionNone
+	}
+	return string(a.CompressionType[:])
+}
+
+// setDataSize updates the fields which depend on the number of bytes of
+// samples.
+func (a *AIFF) setDataSize(dataSize uint64) error {
+	formSize := 4 + (8 + uint64(a.CommSize)) + (8 + 8 + dataSize + dataSize%2)
+	if a.isAIFC() {
+		formSize += 8 + 4
+	}
+	if formSize > math.MaxUint32 {
+		return ErrTooLarge
+	}
+	a.FormSize = uint32(formSize)
+	a.SSNDSize = uint32(8 + dataSize)
+	if frame := uint64(a.NumChannels) * uint64(a.SampleSize/8); frame != 0 {
+		a.NumSampleFrames = uint32(dataSize / frame)
+	}
+	return nil
+}
+
+// encode serializes the chunks which precede the samples.
+func (a *AIFF) encode() []byte {
+	var out bytes.Buffer
+	be := binary.BigEndian
+
+	// Writes to a bytes.Buffer never fail.
+	out.Write(a.FormID[:])
+	_ = binary.Write(&out, be, a.FormSize)
+	out.Write(a.FormType[:])
+
+	if a.isAIFC() {
+		out.WriteString("FVER")
+		_ = binary.Write(&out, be, uint32(4))
+		_ = binary.Write(&out, be, a.FVERTimestamp)
+	}
+
+	out.WriteString("COMM")
+	_ = binary.Write(&out, be, a.CommSize)
+	_ = binary.Write(&out, be, a.NumChannels)
+	_ = binary.Write(&out, be, a.NumSampleFrames)
+	_ = binary.Write(&out, be, a.SampleSize)
+	rate := extended(a.SampleRate)
+	out.Write(rate[:])
+	if a.isAIFC() {
+		out.Write(a.CompressionType[:])
+		out.Write(pstring(a.CompressionName))
+	}
+
+	out.WriteString("SSND")
+	_ = binary.Write(&out, be, a.SSNDSize)
+	_ = binary.Write(&out, be, a.Offset)
+	_ = binary.Write(&out, be, a.BlockSize)
+	return out.Bytes()
+}
+
+// pstring encodes a Pascal-style string: a count byte followed by the text,
+// padded to an even length.
+func pstring(s string) []byte {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	b := append([]byte{byte(len(s))}, s...)
+	if len(b)%2 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// extended encodes a float64 as an 80-bit IEEE 754 extended float.
+func extended(f float64) [10]byte {
+	var b [10]byte
+	if f == 0 || math.IsNaN(f) {
+		return b
+	}
+	var sign uint16
+	if f < 0 {
+		sign = 0x8000
+		f = -f
+	}
+	if math.IsInf(f, 0) {
+		binary.BigEndian.PutUint16(b[:], sign|0x7FFF)
+		b[2] = 0x80
+		return b
+	}
+	frac, exp := math.Frexp(f) // f = frac * 2**exp, with frac in [0.5, 1)
+	binary.BigEndian.PutUint16(b[:], sign|uint16(exp-1+16383))
+	binary.BigEndian.PutUint64(b[2:], uint64(math.Ldexp(frac, 64)))
+	return b
+}
+
+// fromExtended decodes an 80-bit IEEE 754 extended float.
+func fromExtended(b [10]byte) float64 {
+	se := binary.BigEndian.Uint16(b[:])
+	exp := int(se & 0x7FFF)
+	mant := binary.BigEndian.Uint64(b[2:])
+	if exp == 0 && mant == 0 {
+		return 0
+	}
+	f := math.Ldexp(float64(mant), exp-16383-63)
+	if exp == 0x7FFF {
+		f = math.Inf(1)
+	}
+	if se&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
@@ -0,0 +1,176 @@
+package aiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/chaimleib/synth/internal/seekbuf"
+	"github.com/chaimleib/synth/pcm"
+)
+
+func TestExtended(t *testing.T) {
+	cases := []struct {
+		value    float64
+		expected [10]byte
+	}{
+		{0, [10]byte{}},
+		{1, [10]byte{0x3F, 0xFF, 0x80}},
+		{8000, [10]byte{0x40, 0x0B, 0xFA}},
+		{44100, [10]byte{0x40, 0x0E, 0xAC, 0x44}},
+		{48000, [10]byte{0x40, 0x0E, 0xBB, 0x80}},
+		{-2, [10]byte{0xC0, 0x00, 0x80}},
+	}
+	for _, c := range cases {
+		got := extended(c.value)
+		if got != c.expected {
+			t.Errorf("%v: %x (got) != %x (expected)", c.value, got, c.expected)
+		}
+		if back := fromExtended(got); back != c.value {
+			t.Errorf("%x: %v (got) != %v (expected)", got, back, c.value)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		enc  *pcm.Encoder
+		aifc bool
+		sowt bool
+	}{
+		{pcm.New(8000, 1, 1), false, false},
+		{pcm.New(44100, 2, 2), false, false},
+		{pcm.New(48000, 3, 2), false, false},
+		{pcm.New(48000, 4, 1), false, false},
+		{pcm.New(44100, 2, 2), true, false},
+		{pcm.New(44100, 2, 2), true, true},
+		{pcm.NewFloat(48000, 4, 2), true, false},
+		{pcm.NewFloat(48000, 8, 1), true, false},
+	}
+	for _, c := range cases {
+		c := c
+		name := fmt.Sprintf(
+			"rate=%d&channels=%d&depth=%d&format=%d&aifc=%t&sowt=%t",
+			c.enc.Rate, c.enc.Channels, c.enc.Depth, c.enc.Format, c.aifc, c.sowt,
+		)
+		t.Run(name, func(t *testing.T) {
+			src, err := c.enc.Sine(5*time.Millisecond, 440, 0.5, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := NewEncoderFor(c.enc)
+			e.AIFC = c.aifc
+			if c.sowt {
+				e.Compression = CompressionSowt
+			}
+
+			// Write a sample at a time, splitting it across writes.
+			var out bytes.Buffer
+			w, err := e.NewWriter(&out, int64(src.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := src.Bytes()
+			for len(data) > 0 {
+				n := 3
+				if n > len(data) {
+					n = len(data)
+				}
+				if _, err := w.Write(data[:n]); err != nil {
+					t.Fatal(err)
+				}
+				data = data[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			file := out.Bytes()
+
+			d := NewDecoder(bytes.NewReader(file))
+			buf, gotEnc, err := d.Decode()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *gotEnc != *c.enc {
+				t.Errorf("%+v (got) != %+v (expected)", *gotEnc, *c.enc)
+			}
+			if !bytes.Equal(buf.Bytes(), src.Bytes()) {
+				t.Errorf("decoded samples differ from encoded samples")
+			}
+			if got, expected := string(d.AIFF.FormType[:]), "AIFF"; c.aifc || c.enc.Format.IsFloat() {
+				if got != "AIFC" {
+					t.Errorf("%q (got) != %q (expected)", got, "AIFC")
+				}
+			} else if got != expected {
+				t.Errorf("%q (got) != %q (expected)", got, expected)
+			}
+			if got, expected := d.AIFF.FormSize, uint32(len(file)-8); got != expected {
+				t.Errorf("%d (got) != %d (expected) form size", got, expected)
+			}
+		})
+	}
+}
+
+func TestDecodeHugeSound(t *testing.T) {
+	// Sizes claiming far more samples than the file holds should fail
+	// without allocating them all first.
+	file, err := NewEncoderFor(pcm.New(8000, 2, 1)).Encode(bytes.NewReader(make([]byte, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comm := bytes.Index(file, []byte("COMM"))
+	binary.BigEndian.PutUint32(file[comm+10:], 0x70000000) // NumSampleFrames
+	ssnd := bytes.Index(file, []byte("SSND"))
+	binary.BigEndian.PutUint32(file[ssnd+4:], 0xF0000000)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err = Decode(bytes.NewReader(file))
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Error("expected an error")
+	}
+	if got := after.TotalAlloc - before.TotalAlloc; got > 1<<20 {
+		t.Errorf("%d (got) > %d (expected) bytes allocated", got, 1<<20)
+	}
+}
+
+func TestBigEndian(t *testing.T) {
+	enc := pcm.New(8000, 2, 1)
+	file, err := NewEncoderFor(enc).Encode(bytes.NewReader([]byte{0x34, 0x12}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := file[len(file)-2:]; !bytes.Equal(got, []byte{0x12, 0x34}) {
+		t.Errorf("%x (got) != 1234 (expected)", got)
+	}
+}
+
+func TestWriterSeekable(t *testing.T) {
+	enc := pcm.New(8000, 1, 1)
+	samples := []byte{0x80, 0x90, 0xa0}
+	e := NewEncoderFor(enc)
+
+	expected, err := e.Encode(bytes.NewReader(samples))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out seekbuf.Buffer
+	w, err := e.NewWriter(&out, UnknownSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Errorf("%v (got) != %v (expected)", out.Bytes(), expected)
+	}
+}
@@ -0,0 +1,291 @@
+package aiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+var (
+	errNotFORM    = errors.New("not an IFF FORM file")
+	errNotAIFF    = errors.New("not an AIFF or AIFF-C file")
+	errNoCommon   = errors.New("missing COMM chunk")
+	errNoData     = errors.New("missing SSND chunk")
+	errDataBefore = errors.New("SSND chunk precedes COMM chunk")
+)
+
+// readSamples is how many samples readSound converts at a time.
+const readSamples = 8192
+
+// chunkHeader starts every chunk inside the FORM chunk.
+type chunkHeader struct {
+	ID   [4]byte
+	Size uint32
+}
+
+// commonChunk is the body of an AIFF COMM chunk.
+type commonChunk struct {
+	NumChannels     uint16
+	NumSampleFrames uint32
+	SampleSize      uint16
+	SampleRate      [10]byte
+}
+
+// Decoder reads AIFF and AIFF-C files.
+type Decoder struct {
+	r io.Reader
+
+	// AIFF holds the header fields of the last decoded file.
+	AIFF AIFF
+}
+
+// NewDecoder creates a Decoder which reads an AIFF file from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads an AIFF file from r, returning its samples and a matching
+// Encoder.
+func Decode(r io.Reader) (*pcm.Buffer, *pcm.Encoder, error) {
+	return NewDecoder(r).Decode()
+}
+
+// Decode reads the AIFF file, returning its samples, converted to the
+// little-endian layout of a pcm.Buffer, and a matching Encoder. Chunks other
+// than COMM and SSND are skipped.
+func (d *Decoder) Decode() (*pcm.Buffer, *pcm.Encoder, error) {
+	a := &d.AIFF
+	*a = AIFF{}
+	be := binary.BigEndian
+
+	var form chunkHeader
+	if err := binary.Read(d.r, be, &form); err != nil {
+		return nil, nil, err
+	}
+	if string(form.ID[:]) != "FORM" {
+		return nil, nil, errNotFORM
+	}
+	a.FormID = form.ID
+	a.FormSize = form.Size
+	if _, err := io.ReadFull(d.r, a.FormType[:]); err != nil {
+		return nil, nil, err
+	}
+	if !a.isAIFC() && string(a.FormType[:]) != "AIFF" {
+		return nil, nil, errNotAIFF
+	}
+
+	var (
+		enc     *pcm.Encoder
+		buf     *pcm.Buffer
+		hasData bool
+	)
+	for {
+		var ch chunkHeader
+		err := binary.Read(d.r, be, &ch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch string(ch.ID[:]) {
+		case "FVER":
+			if ch.Size >= 4 {
+				if err := binary.Read(d.r, be, &a.FVERTimestamp); err != nil {
+					return nil, nil, err
+				}
+				ch.Size -= 4
+			}
+			if err := d.skip(ch.Size); err != nil {
+				return nil, nil, err
+			}
+
+		case "COMM":
+			if err := d.readCommon(ch); err != nil {
+				return nil, nil, err
+			}
+			if enc, err = a.pcmEncoder(); err != nil {
+				return nil, nil, err
+			}
+
+		case "SSND":
+			if enc == nil {
+				return nil, nil, errDataBefore
+			}
+			if buf, err = d.readSound(ch, enc); err != nil {
+				return nil, nil, err
+			}
+			hasData = true
+
+		default:
+			if err := d.skip(ch.Size); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if enc == nil {
+		return nil, nil, errNoCommon
+	}
+	if !hasData {
+		return nil, nil, errNoData
+	}
+	return buf, enc, nil
+}
+
+// readCommon reads the body of a COMM chunk into the AIFF header fields.
+func (d *Decoder) readCommon(ch chunkHeader) error {
+	if ch.Size < 18 {
+		return fmt.Errorf("COMM chunk too short: %d bytes", ch.Size)
+	}
+	var c commonChunk
+	if err := binary.Read(d.r, binary.BigEndian, &c); err != nil {
+		return err
+	}
+	a := &d.AIFF
+	a.CommSize = ch.Size
+	a.NumChannels = c.NumChannels
+	a.NumSampleFrames = c.NumSampleFrames
+	a.SampleSize = c.SampleSize
+	a.SampleRate = fromExtended(c.SampleRate)
+	rest := ch.Size - 18
+
+	if a.isAIFC() {
+		if rest < 5 {
+			return fmt.Errorf("AIFF-C COMM chunk too short: %d bytes", ch.Size)
+		}
+		if _, err := io.ReadFull(d.r, a.CompressionType[:]); err != nil {
+			return err
+		}
+		var n [1]byte
+		if _, err := io.ReadFull(d.r, n[:]); err != nil {
+			return err
+		}
+		rest -= 5
+		if uint32(n[0]) > rest {
+			return fmt.Errorf("compression name overruns the COMM chunk")
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(d.r, name); err != nil {
+			return err
+		}
+		a.CompressionName = string(name)
+		rest -= uint32(n[0])
+	}
+
+	if _, err := io.CopyN(io.Discard, d.r, int64(rest)); err != nil {
+		return err
+	}
+	return d.skipPad(ch.Size)
+}
+
+// readSound reads the body of an SSND chunk, converting the samples for a
+// pcm.Buffer.
+func (d *Decoder) readSound(ch chunkHeader, enc *pcm.Encoder) (*pcm.Buffer, error) {
+	a := &d.AIFF
+	if ch.Size < 8 {
+		return nil, fmt.Errorf("SSND chunk too short: %d bytes", ch.Size)
+	}
+	a.SSNDSize = ch.Size
+	if err := binary.Read(d.r, binary.BigEndian, &a.Offset); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(d.r, binary.BigEndian, &a.BlockSize); err != nil {
+		return nil, err
+	}
+	if a.Offset > ch.Size-8 {
+		return nil, fmt.Errorf("SSND offset %d overruns the chunk", a.Offset)
+	}
+	if _, err := io.CopyN(io.Discard, d.r, int64(a.Offset)); err != nil {
+		return nil, err
+	}
+
+	// Trust NumSampleFrames over the chunk size, which may include padding
+	// for block alignment.
+	frame := uint64(enc.Channels * enc.Depth)
+	size := uint64(ch.Size - 8 - a.Offset)
+	if want := uint64(a.NumSampleFrames) * frame; want <= size {
+		size = want
+	} else {
+		size -= size % frame
+	}
+
+	buf, err := enc.NewBuffer(0)
+	if err != nil {
+		return nil, err
+	}
+	// Convert a whole number of samples at a time, so that a bogus size
+	// fails at the end of the file rather than with a huge allocation.
+	block := make([]byte, enc.Depth*readSamples)
+	for left := size; left > 0; {
+		p := block[:min(left, uint64(len(block)))]
+		if _, err := io.ReadFull(d.r, p); err != nil {
+			return nil, err
+		}
+		convert(p, a.compression(), enc.Depth)
+		_, _ = buf.Write(p) // always returns nil error
+		left -= uint64(len(p))
+	}
+
+	rest := ch.Size - 8 - a.Offset - uint32(size)
+	if _, err := io.CopyN(io.Discard, d.r, int64(rest)); err != nil {
+		return nil, err
+	}
+	if err := d.skipPad(ch.Size); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// skip discards a chunk body of the given size, including its pad byte.
+func (d *Decoder) skip(size uint32) error {
+	if _, err := io.CopyN(io.Discard, d.r, int64(size)); err != nil {
+		return err
+	}
+	return d.skipPad(size)
+}
+
+// skipPad discards the pad byte which follows chunks of odd size. A missing
+// pad byte at the end of the file is tolerated.
+func (d *Decoder) skipPad(size uint32) error {
+	if size%2 == 0 {
+		return nil
+	}
+	var pad [1]byte
+	if _, err := io.ReadFull(d.r, pad[:]); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// pcmEncoder returns the pcm.Encoder matching the COMM chunk, or an error if
+// it describes audio that pcm can't represent.
+func (a *AIFF) pcmEncoder() (*pcm.Encoder, error) {
+	if a.NumChannels == 0 {
+		return nil, errors.New("no channels")
+	}
+	if a.SampleRate < 1 || a.SampleRate > 1<<31 {
+		return nil, fmt.Errorf("invalid sample rate: %g", a.SampleRate)
+	}
+	rate := int(a.SampleRate + 0.5)
+	channels := int(a.NumChannels)
+
+	// Integer samples are padded up to whole bytes.
+	depth := int(a.SampleSize+7) / 8
+	switch a.compression() {
+	case CompressionNone, CompressionSowt:
+		if depth < 1 || depth > 4 {
+			return nil, fmt.Errorf("unsupported sample size: %d", a.SampleSize)
+		}
+		return pcm.New(rate, depth, channels), nil
+	case CompressionFloat32, "FL32":
+		return pcm.NewFloat(rate, 4, channels), nil
+	case CompressionFloat64, "FL64":
+		return pcm.NewFloat(rate, 8, channels), nil
+	}
+	return nil, fmt.Errorf("unsupported compression type: %q", a.CompressionType[:])
+}
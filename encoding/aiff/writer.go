@@ -0,0 +1,192 @@
+package aiff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// UnknownSize may be passed to NewWriter when the amount of audio is not known
+// in advance. The destination must then be an io.WriteSeeker.
+const UnknownSize = -1
+
+var (
+	errNeedSeeker = errors.New("unknown data size requires an io.WriteSeeker")
+	errClosed     = errors.New("write to closed Writer")
+)
+
+// Writer streams audio samples into an AIFF file without holding the whole
+// file in memory. Samples are converted from the little-endian layout of a
+// pcm.Buffer as they are written.
+type Writer struct {
+	w           io.Writer
+	header      *AIFF
+	compression string
+	depth       int
+
+	// start is where the header begins in a seekable destination.
+	start int64
+	// size is the promised number of bytes of samples, or UnknownSize.
+	size    int64
+	written int64
+	// partial holds the bytes of a sample split across calls to Write.
+	partial []byte
+	closed  bool
+}
+
+var _ io.WriteCloser = (*Writer)(nil)
+
+// NewWriter writes an AIFF header to w and returns a Writer for the samples.
+//
+// If w is an io.WriteSeeker, dataSize may be UnknownSize, and the header is
+// patched with the real sizes on Close. Otherwise, dataSize must be the exact
+// number of bytes of samples that will be written.
+func (e *encoder) NewWriter(w io.Writer, dataSize int64) (*Writer, error) {
+	switch e.Compression {
+	case CompressionNone, CompressionSowt:
+		if e.ByteDepth < 1 || e.ByteDepth > 4 {
+			return nil, fmt.Errorf("unsupported byte depth: %d", e.ByteDepth)
+		}
+	case CompressionFloat32:
+		if e.ByteDepth != 4 {
+			return nil, fmt.Errorf("fl32 needs a byte depth of 4, not %d", e.ByteDepth)
+		}
+	case CompressionFloat64:
+		if e.ByteDepth != 8 {
+			return nil, fmt.Errorf("fl64 needs a byte depth of 8, not %d", e.ByteDepth)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression type: %q", e.Compression)
+	}
+
+	ww := &Writer{
+		w:           w,
+		compression: e.Compression,
+		depth:       e.ByteDepth,
+		size:        dataSize,
+	}
+
+	if ws, ok := w.(io.WriteSeeker); ok {
+		start, err := ws.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		ww.start = start
+	} else if dataSize == UnknownSize {
+		return nil, errNeedSeeker
+	}
+
+	if dataSize < UnknownSize {
+		return nil, fmt.Errorf("invalid data size: %d", dataSize)
+	}
+	var headerSize uint64
+	if dataSize != UnknownSize {
+		headerSize = uint64(dataSize)
+	}
+	header, err := e.header(headerSize)
+	if err != nil {
+		return nil, err
+	}
+	ww.header = header
+
+	// Write the fixed-length header chunks.
+	if _, err := w.Write(ww.header.encode()); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// Write appends audio samples, encoded as in a pcm.Buffer, to the file.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.size != UnknownSize && w.written+int64(len(w.partial)+len(p)) > w.size {
+		return 0, fmt.Errorf("wrote more than the promised %d bytes", w.size)
+	}
+
+	// Convert whole samples only, and keep the rest for next time.
+	buf := append(w.partial, p...)
+	whole := len(buf) - len(buf)%w.depth
+	out := make([]byte, whole)
+	copy(out, buf[:whole])
+	w.partial = append(w.partial[:0], buf[whole:]...)
+	convert(out, w.compression, w.depth)
+
+	n, err := w.w.Write(out)
+	w.written += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close finishes the SSND chunk and, for seekable destinations, patches the
+// header with the final sizes. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if len(w.partial) != 0 {
+		return fmt.Errorf("%d bytes of a partial sample left over", len(w.partial))
+	}
+	if w.written%2 != 0 {
+		if _, err := w.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	if w.size != UnknownSize {
+		if w.written != w.size {
+			return fmt.Errorf("wrote %d bytes, but promised %d", w.written, w.size)
+		}
+		return nil
+	}
+
+	if err := w.header.setDataSize(uint64(w.written)); err != nil {
+		return err
+	}
+	return w.patch()
+}
+
+// patch rewrites the header with the final sizes, and then returns to the
+// end of the file.
+func (w *Writer) patch() error {
+	ws := w.w.(io.WriteSeeker)
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := ws.Seek(w.start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(w.header.encode()); err != nil {
+		return err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// convert switches whole samples between the little-endian layout of a
+// pcm.Buffer, where 8-bit samples are unsigned, and the layout of the given
+// compression type. It is its own inverse.
+func convert(p []byte, compression string, depth int) {
+	if compression == CompressionSowt && depth != 1 {
+		return // already little-endian
+	}
+	if depth == 1 {
+		// AIFF 8-bit samples are signed.
+		for i := range p {
+			p[i] ^= 0x80
+		}
+		return
+	}
+	for i := 0; i+depth <= len(p); i += depth {
+		s := p[i : i+depth]
+		for j, k := 0, depth-1; j < k; j, k = j+1, k-1 {
+			s[j], s[k] = s[k], s[j]
+		}
+	}
+}
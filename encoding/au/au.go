@@ -0,0 +1,183 @@
+// Package au allows reading and writing audio data in Sun/NeXT .au files,
+// including the G.711 mu-law and A-law encodings used in telephony.
+package au
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+// AU holds the fields of the file header. All fields are big-endian. Source:
+// the Sun audio_filehdr.h header, and NeXT's soundstruct.h.
+type AU struct {
+	Magic      [4]byte // value:".snd"
+	DataOffset uint32  // 24 + size of the annotation
+	DataSize   uint32  // UnknownDataSize if not known
+	Encoding   uint32
+	SampleRate uint32
+	Channels   uint32
+	// Annotation is free text between the header and the samples, padded
+	// with NULs.
+	Annotation string
+	// SampledData has to be appended manually.
+}
+
+// Encodings, which select the sample format.
+const (
+	EncodingMuLaw    = 1 // 8-bit G.711 mu-law
+	EncodingLinear8  = 2 // 8-bit signed PCM
+	EncodingLinear16 = 3 // 16-bit signed PCM
+	EncodingLinear24 = 4 // 24-bit signed PCM
+	EncodingLinear32 = 5 // 32-bit signed PCM
+	EncodingFloat    = 6 // 32-bit IEEE 754 float
+	EncodingDouble   = 7 // 64-bit IEEE 754 float
+	EncodingALaw     = 27
+)
+
+// UnknownDataSize is stored in DataSize when the amount of audio wasn't known
+// when the header was written. Readers then read to the end of the file.
+const UnknownDataSize = math.MaxUint32
+
+// headerSize is the size of the fixed header fields.
+const headerSize = 24
+
+type encoder struct {
+	Encoding    uint32
+	NbrChannels uint32
+	Frequency   uint32
+	ByteDepth   int
+
+	// Annotation is written between the header and the samples.
+	Annotation string
+}
+
+// NewEncoder creates a new encoder, which describes the format of the audio
+// samples to be encoded. The samples are taken in the same little-endian
+// layout as a pcm.Buffer, with 8-bit linear samples unsigned.
+func NewEncoder(encoding, nbrChannels, byteDepth, frequency int) *encoder {
+	return &encoder{
+		Encoding:    uint32(encoding),
+		NbrChannels: uint32(nbrChannels),
+		Frequency:   uint32(frequency),
+		ByteDepth:   byteDepth,
+	}
+}
+
+// NewEncoderFor creates a new encoder for samples produced by a pcm.Encoder,
+// picking the encoding which matches its sample format.
+func NewEncoderFor(enc *pcm.Encoder) *encoder {
+	encoding := EncodingLinear8 + enc.Depth - 1
+	switch enc.Format {
+	case pcm.FormatMuLaw:
+		encoding = EncodingMuLaw
+	case pcm.FormatALaw:
+		encoding = EncodingALaw
+	case pcm.FormatFloat32:
+		encoding = EncodingFloat
+	case pcm.FormatFloat64:
+		encoding = EncodingDouble
+	}
+	return NewEncoder(encoding, enc.Channels, enc.Depth, enc.Rate)
+}
+
+// Encode takes an io.Reader and returns a buffer containing an AU file.
+func (e *encoder) Encode(r io.Reader) ([]byte, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	w, err := e.NewWriter(&out, int64(len(buf)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the audio samples.
+	if _, err = w.Write(buf); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// validate checks that the encoding matches the byte depth.
+func (e *encoder) validate() error {
+	depth, ok := encodingDepths[e.Encoding]
+	if !ok {
+		return fmt.Errorf("unsupported encoding: %d", e.Encoding)
+	}
+	if e.ByteDepth != depth {
+		return fmt.Errorf("encoding %d needs a byte depth of %d, not %d", e.Encoding, depth, e.ByteDepth)
+	}
+	return nil
+}
+
+// encodingDepths are the byte depths of the supported encodings.
+var encodingDepths = map[uint32]int{
+	EncodingMuLaw:    1,
+	EncodingLinear8:  1,
+	EncodingLinear16: 2,
+	EncodingLinear24: 3,
+	EncodingLinear32: 4,
+	EncodingFloat:    4,
+	EncodingDouble:   8,
+	EncodingALaw:     1,
+}
+
+// header returns the file header for the given number of bytes of samples.
+func (e *encoder) header(dataSize uint64) *AU {
+	a := &AU{
+		Encoding:   e.Encoding,
+		SampleRate: e.Frequency,
+		Channels:   e.NbrChannels,
+		Annotation: e.Annotation,
+	}
+	copy(a.Magic[:], ".snd")
+	a.DataOffset = uint32(headerSize + len(annotation(a.Annotation)))
+	a.setDataSize(dataSize)
+	return a
+}
+
+// setDataSize updates the data size field. Sizes which don't fit are stored
+// as UnknownDataSize, so that readers read to the end of the file.
+func (a *AU) setDataSize(dataSize uint64) {
+	if dataSize > UnknownDataSize {
+		dataSize = UnknownDataSize
+	}
+	a.DataSize = uint32(dataSize)
+}
+
+// encode serializes the header and annotation which precede the samples.
+func (a *AU) encode() []byte {
+	var out bytes.Buffer
+	be := binary.BigEndian
+
+	// Writes to a bytes.Buffer never fail.
+	out.Write(a.Magic[:])
+	_ = binary.Write(&out, be, a.DataOffset)
+	_ = binary.Write(&out, be, a.DataSize)
+	_ = binary.Write(&out, be, a.Encoding)
+	_ = binary.Write(&out, be, a.SampleRate)
+	_ = binary.Write(&out, be, a.Channels)
+	out.Write(annotation(a.Annotation))
+	return out.Bytes()
+}
+
+// annotation encodes the annotation text, NUL-terminated and padded to a
+// multiple of 4 bytes. Even an empty annotation takes 4 bytes.
+func annotation(s string) []byte {
+	b := append([]byte(s), 0)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,133 @@
+package au
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+func TestRoundTrip(t *testing.T) {
+	encodings := []*pcm.Encoder{
+		pcm.NewMuLaw(8000, 1),
+		pcm.NewALaw(8000, 1),
+		pcm.New(8000, 1, 1),
+		pcm.New(44100, 2, 2),
+		pcm.New(48000, 3, 2),
+		pcm.New(48000, 4, 1),
+		pcm.NewFloat(48000, 4, 2),
+		pcm.NewFloat(48000, 8, 1),
+	}
+	for _, enc := range encodings {
+		enc := enc
+		name := fmt.Sprintf(
+			"rate=%d&channels=%d&depth=%d&format=%d",
+			enc.Rate, enc.Channels, enc.Depth, enc.Format,
+		)
+		t.Run(name, func(t *testing.T) {
+			src, err := enc.Sine(5*time.Millisecond, 440, 0.5, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := NewEncoderFor(enc)
+			e.Annotation = "test tone"
+			file, err := e.Encode(bytes.NewReader(src.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := NewDecoder(bytes.NewReader(file))
+			buf, gotEnc, err := d.Decode()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *gotEnc != *enc {
+				t.Errorf("%+v (got) != %+v (expected)", *gotEnc, *enc)
+			}
+			if !bytes.Equal(buf.Bytes(), src.Bytes()) {
+				t.Errorf("decoded samples differ from encoded samples")
+			}
+			if got, expected := d.AU.Annotation, e.Annotation; got != expected {
+				t.Errorf("%q (got) != %q (expected)", got, expected)
+			}
+			if got, expected := d.AU.DataSize, uint32(src.Len()); got != expected {
+				t.Errorf("%d (got) != %d (expected) data size", got, expected)
+			}
+		})
+	}
+}
+
+func TestHeader(t *testing.T) {
+	enc := pcm.NewMuLaw(8000, 1)
+	silence, err := enc.NewSilence(time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := NewEncoderFor(enc).Encode(bytes.NewReader(silence.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []uint32{0x2e736e64, 28, 8, EncodingMuLaw, 8000, 1}
+	for i, want := range expected {
+		if got := binary.BigEndian.Uint32(file[4*i:]); got != want {
+			t.Errorf("field %d: %d (got) != %d (expected)", i, got, want)
+		}
+	}
+	if got := file[28:]; !bytes.Equal(got, bytes.Repeat([]byte{0xFF}, 8)) {
+		t.Errorf("%x (got) != ffffffffffffffff (expected)", got)
+	}
+}
+
+func TestUnknownSize(t *testing.T) {
+	enc := pcm.NewALaw(8000, 1)
+	samples := []byte{0xD5, 0x55, 0x2A}
+
+	var out bytes.Buffer
+	w, err := NewEncoderFor(enc).NewWriter(&out, UnknownSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(bytes.NewReader(out.Bytes()))
+	buf, _, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.AU.DataSize != UnknownDataSize {
+		t.Errorf("%#x (got) != %#x (expected)", d.AU.DataSize, UnknownDataSize)
+	}
+	if !bytes.Equal(buf.Bytes(), samples) {
+		t.Errorf("%x (got) != %x (expected)", buf.Bytes(), samples)
+	}
+}
+
+func TestHugeAnnotation(t *testing.T) {
+	// An offset far beyond the end of the file should fail without
+	// allocating the annotation first.
+	var file []byte
+	for _, v := range []uint32{0x2e736e64, 0xF0000000, UnknownDataSize, EncodingMuLaw, 8000, 1} {
+		file = binary.BigEndian.AppendUint32(file, v)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err := Decode(bytes.NewReader(file))
+	runtime.ReadMemStats(&after)
+	if err == nil {
+		t.Error("expected an error")
+	}
+	if got := after.TotalAlloc - before.TotalAlloc; got > 1<<20 {
+		t.Errorf("%d (got) > %d (expected) bytes allocated", got, 1<<20)
+	}
+}
@@ -0,0 +1,128 @@
+package au
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+var errNotAU = errors.New("not an AU file")
+
+// Decoder reads AU files.
+type Decoder struct {
+	r io.Reader
+
+	// AU holds the header fields of the last decoded file.
+	AU AU
+}
+
+// NewDecoder creates a Decoder which reads an AU file from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads an AU file from r, returning its samples and a matching
+// Encoder.
+func Decode(r io.Reader) (*pcm.Buffer, *pcm.Encoder, error) {
+	return NewDecoder(r).Decode()
+}
+
+// Decode reads the AU file, returning its samples, converted to the
+// little-endian layout of a pcm.Buffer, and a matching Encoder. If the header
+// gives no data size, samples are read to the end of the file.
+func (d *Decoder) Decode() (*pcm.Buffer, *pcm.Encoder, error) {
+	a := &d.AU
+	*a = AU{}
+
+	var fixed [headerSize]byte
+	if _, err := io.ReadFull(d.r, fixed[:]); err != nil {
+		return nil, nil, err
+	}
+	be := binary.BigEndian
+	copy(a.Magic[:], fixed[:4])
+	if string(a.Magic[:]) != ".snd" {
+		return nil, nil, errNotAU
+	}
+	a.DataOffset = be.Uint32(fixed[4:])
+	a.DataSize = be.Uint32(fixed[8:])
+	a.Encoding = be.Uint32(fixed[12:])
+	a.SampleRate = be.Uint32(fixed[16:])
+	a.Channels = be.Uint32(fixed[20:])
+
+	if a.DataOffset < headerSize {
+		return nil, nil, fmt.Errorf("data offset %d overlaps the header", a.DataOffset)
+	}
+	// Read the annotation as it arrives, rather than trusting the offset in
+	// the header with a large allocation.
+	n := a.DataOffset - headerSize
+	note, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(note) != int(n) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	if i := bytes.IndexByte(note, 0); i >= 0 {
+		note = note[:i]
+	}
+	a.Annotation = string(note)
+
+	enc, err := a.pcmEncoder()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := d.r
+	if a.DataSize != UnknownDataSize {
+		r = io.LimitReader(r, int64(a.DataSize))
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if a.DataSize != UnknownDataSize && len(data) != int(a.DataSize) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	// Drop a trailing partial sample.
+	frame := enc.Channels * enc.Depth
+	data = data[:len(data)-len(data)%frame]
+	convert(data, a.Encoding, enc.Depth)
+
+	buf, err := enc.NewBuffer(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, _ = buf.Write(data) // always returns nil error
+	return buf, enc, nil
+}
+
+// pcmEncoder returns the pcm.Encoder matching the header, or an error if it
+// describes audio that pcm can't represent.
+func (a *AU) pcmEncoder() (*pcm.Encoder, error) {
+	if a.Channels == 0 || a.Channels > 1<<16 {
+		return nil, fmt.Errorf("invalid channel count: %d", a.Channels)
+	}
+	if a.SampleRate == 0 || a.SampleRate > 1<<31-1 {
+		return nil, fmt.Errorf("invalid sample rate: %d", a.SampleRate)
+	}
+	rate := int(a.SampleRate)
+	channels := int(a.Channels)
+
+	switch a.Encoding {
+	case EncodingMuLaw:
+		return pcm.NewMuLaw(rate, channels), nil
+	case EncodingALaw:
+		return pcm.NewALaw(rate, channels), nil
+	case EncodingLinear8, EncodingLinear16, EncodingLinear24, EncodingLinear32:
+		return pcm.New(rate, encodingDepths[a.Encoding], channels), nil
+	case EncodingFloat:
+		return pcm.NewFloat(rate, 4, channels), nil
+	case EncodingDouble:
+		return pcm.NewFloat(rate, 8, channels), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding: %d", a.Encoding)
+}
@@ -0,0 +1,165 @@
+package au
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// UnknownSize may be passed to NewWriter when the amount of audio is not known
+// in advance.
+const UnknownSize = -1
+
+var errClosed = errors.New("write to closed Writer")
+
+// Writer streams audio samples into an AU file without holding the whole file
+// in memory. Samples are converted from the little-endian layout of a
+// pcm.Buffer as they are written.
+type Writer struct {
+	w        io.Writer
+	header   *AU
+	encoding uint32
+	depth    int
+
+	// start is where the header begins in a seekable destination.
+	start    int64
+	seekable bool
+	// size is the promised number of bytes of samples, or UnknownSize.
+	size    int64
+	written int64
+	// partial holds the bytes of a sample split across calls to Write.
+	partial []byte
+	closed  bool
+}
+
+var _ io.WriteCloser = (*Writer)(nil)
+
+// NewWriter writes an AU header to w and returns a Writer for the samples.
+//
+// If dataSize is UnknownSize, the header says so, which AU readers accept.
+// If w is also an io.WriteSeeker, the header is patched with the real size
+// on Close. Otherwise, dataSize must be the exact number of bytes of samples
+// that will be written.
+func (e *encoder) NewWriter(w io.Writer, dataSize int64) (*Writer, error) {
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	if dataSize < UnknownSize {
+		return nil, fmt.Errorf("invalid data size: %d", dataSize)
+	}
+
+	ww := &Writer{
+		w:        w,
+		encoding: e.Encoding,
+		depth:    e.ByteDepth,
+		size:     dataSize,
+	}
+	if ws, ok := w.(io.WriteSeeker); ok {
+		start, err := ws.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		ww.start = start
+		ww.seekable = true
+	}
+
+	headerSize := uint64(UnknownDataSize)
+	if dataSize != UnknownSize {
+		headerSize = uint64(dataSize)
+	}
+	ww.header = e.header(headerSize)
+	if _, err := w.Write(ww.header.encode()); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// Write appends audio samples, encoded as in a pcm.Buffer, to the file.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.size != UnknownSize && w.written+int64(len(w.partial)+len(p)) > w.size {
+		return 0, fmt.Errorf("wrote more than the promised %d bytes", w.size)
+	}
+
+	// Convert whole samples only, and keep the rest for next time.
+	buf := append(w.partial, p...)
+	whole := len(buf) - len(buf)%w.depth
+	out := make([]byte, whole)
+	copy(out, buf[:whole])
+	w.partial = append(w.partial[:0], buf[whole:]...)
+	convert(out, w.encoding, w.depth)
+
+	n, err := w.w.Write(out)
+	w.written += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close checks that the promised data was written and, for seekable
+// destinations of unknown size, patches the header with the real size. It
+// does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if len(w.partial) != 0 {
+		return fmt.Errorf("%d bytes of a partial sample left over", len(w.partial))
+	}
+	if w.size != UnknownSize {
+		if w.written != w.size {
+			return fmt.Errorf("wrote %d bytes, but promised %d", w.written, w.size)
+		}
+		return nil
+	}
+	if !w.seekable {
+		return nil
+	}
+	w.header.setDataSize(uint64(w.written))
+	return w.patch()
+}
+
+// patch rewrites the header with the final size, and then returns to the end
+// of the file.
+func (w *Writer) patch() error {
+	ws := w.w.(io.WriteSeeker)
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := ws.Seek(w.start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(w.header.encode()); err != nil {
+		return err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// convert switches whole samples between the little-endian layout of a
+// pcm.Buffer, where 8-bit linear samples are unsigned, and the big-endian
+// layout of the given encoding. It is its own inverse.
+func convert(p []byte, encoding uint32, depth int) {
+	switch encoding {
+	case EncodingMuLaw, EncodingALaw:
+		return // stored as-is
+	case EncodingLinear8:
+		// AU 8-bit samples are signed.
+		for i := range p {
+			p[i] ^= 0x80
+		}
+		return
+	}
+	for i := 0; i+depth <= len(p); i += depth {
+		s := p[i : i+depth]
+		for j, k := 0, depth-1; j < k; j, k = j+1, k-1 {
+			s[j], s[k] = s[k], s[j]
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package flac
+
+import (
+	"bufio"
+	"io"
+)
+
+// bitWriter packs values into bytes, most significant bit first.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64 // pending bits, right-aligned
+	nbits uint   // number of pending bits
+}
+
+// writeBits appends the low n bits of v, for n up to 32.
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.nbits -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.nbits))
+	}
+}
+
+// writeSigned appends v as an n-bit two's complement value.
+func (w *bitWriter) writeSigned(v int64, n uint) {
+	w.writeBits(uint64(v), n)
+}
+
+// writeUnary appends v zero bits followed by a one bit.
+func (w *bitWriter) writeUnary(v uint64) {
+	for v >= 32 {
+		w.writeBits(0, 32)
+		v -= 32
+	}
+	w.writeBits(1, uint(v)+1)
+}
+
+// writeRice appends v with Rice coding, using parameter k.
+func (w *bitWriter) writeRice(v int64, k uint) {
+	u := zigzag(v)
+	w.writeUnary(u >> k)
+	w.writeBits(u, k)
+}
+
+// align pads with zero bits up to the next byte boundary.
+func (w *bitWriter) align() {
+	if w.nbits != 0 {
+		w.writeBits(0, 8-w.nbits)
+	}
+}
+
+// bytes returns the packed bytes. The writer must be aligned.
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
+
+// bitReader unpacks values from a byte stream, most significant bit first,
+// keeping running CRCs of the bytes it consumes.
+type bitReader struct {
+	r     *bufio.Reader
+	acc   uint64
+	nbits uint
+	crc8  uint8
+	crc16 uint16
+}
+
+func newBitReader(r io.Reader) *bitReader {
+	return &bitReader{r: bufio.NewReader(r)}
+}
+
+// readByte consumes a whole byte, updating the CRCs.
+func (r *bitReader) readByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.crc8 = crc8Table[r.crc8^b]
+	r.crc16 = r.crc16<<8 ^ crc16Table[byte(r.crc16>>8)^b]
+	return b, nil
+}
+
+// readBits consumes n bits, for n up to 56.
+func (r *bitReader) readBits(n uint) (uint64, error) {
+	for r.nbits < n {
+		b, err := r.readByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.acc = r.acc<<8 | uint64(b)
+		r.nbits += 8
+	}
+	r.nbits -= n
+	v := r.acc >> r.nbits & (1<<n - 1)
+	return v, nil
+}
+
+// readSigned consumes an n-bit two's complement value.
+func (r *bitReader) readSigned(n uint) (int64, error) {
+	v, err := r.readBits(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary consumes zero bits up to and including a one bit, returning
+// the number of zeros.
+func (r *bitReader) readUnary() (uint64, error) {
+	var v uint64
+	for {
+		bit, err := r.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			return v, nil
+		}
+		v++
+	}
+}
+
+// readRice consumes a Rice-coded value with parameter k.
+func (r *bitReader) readRice(k uint) (int64, error) {
+	q, err := r.readUnary()
+	if err != nil {
+		return 0, err
+	}
+	low, err := r.readBits(k)
+	if err != nil {
+		return 0, err
+	}
+	return unzigzag(q<<k | low), nil
+}
+
+// align discards bits up to the next byte boundary.
+func (r *bitReader) align() {
+	r.nbits -= r.nbits % 8
+}
+
+// resetCRC starts new CRCs from the next byte. The reader must be aligned.
+func (r *bitReader) resetCRC() {
+	r.crc8 = 0
+	r.crc16 = 0
+}
+
+// zigzag folds signed values into unsigned ones: 0, -1, 1, -2, ... become
+// 0, 1, 2, 3, ...
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func unzigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+var (
+	crc8Table  [256]uint8
+	crc16Table [256]uint16
+)
+
+func init() {
+	// CRC-8 with polynomial x^8 + x^2 + x + 1, and CRC-16 with polynomial
+	// x^16 + x^15 + x^2 + 1, both unreflected with zero initial values.
+	for i := range crc8Table {
+		c := uint8(i)
+		for j := 0; j < 8; j++ {
+			if c&0x80 != 0 {
+				c = c<<1 ^ 0x07
+			} else {
+				c <<= 1
+			}
+		}
+		crc8Table[i] = c
+
+		d := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if d&0x8000 != 0 {
+				d = d<<1 ^ 0x8005
+			} else {
+				d <<= 1
+			}
+		}
+		crc16Table[i] = d
+	}
+}
+
+// crc8 computes the CRC-8 of a frame header.
+func crc8(p []byte) uint8 {
+	var c uint8
+	for _, b := range p {
+		c = crc8Table[c^b]
+	}
+	return c
+}
+
+// crc16 computes the CRC-16 of a frame.
+func crc16(p []byte) uint16 {
+	var c uint16
+	for _, b := range p {
+		c = c<<8 ^ crc16Table[byte(c>>8)^b]
+	}
+	return c
+}
@@ -0,0 +1,510 @@
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+var (
+	errNotFLAC       = errors.New("not a FLAC file")
+	errNoStreamInfo  = errors.New("missing STREAMINFO block")
+	errBadSync       = errors.New("lost frame sync")
+	errHeaderCRC     = errors.New("frame header CRC mismatch")
+	errFrameCRC      = errors.New("frame CRC mismatch")
+	errMD5           = errors.New("MD5 checksum mismatch")
+	errReservedValue = errors.New("reserved value in frame")
+)
+
+// Decoder reads FLAC files.
+type Decoder struct {
+	r *bitReader
+
+	// StreamInfo holds the STREAMINFO block of the last decoded file.
+	StreamInfo StreamInfo
+}
+
+// NewDecoder creates a Decoder which reads a FLAC file from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: newBitReader(r)}
+}
+
+// Decode reads a FLAC file from r, returning its samples and a matching
+// Encoder.
+func Decode(r io.Reader) (*pcm.Buffer, *pcm.Encoder, error) {
+	return NewDecoder(r).Decode()
+}
+
+// Decode reads the FLAC file, returning its samples and a matching Encoder.
+// Samples whose bit depth isn't a multiple of 8 are scaled up to fill whole
+// bytes. Metadata blocks other than STREAMINFO are skipped. If STREAMINFO
+// has an MD5 checksum, it is verified.
+func (d *Decoder) Decode() (*pcm.Buffer, *pcm.Encoder, error) {
+	if err := d.readMetadata(); err != nil {
+		return nil, nil, err
+	}
+	s := &d.StreamInfo
+	if s.NbrChannels == 0 || s.BitsPerSample < 4 || s.SampleRate == 0 {
+		return nil, nil, fmt.Errorf("invalid STREAMINFO block: %+v", *s)
+	}
+	if s.BitsPerSample > 32 {
+		return nil, nil, fmt.Errorf("unsupported bits per sample: %d", s.BitsPerSample)
+	}
+
+	depth := (int(s.BitsPerSample) + 7) / 8
+	enc := pcm.New(int(s.SampleRate), depth, int(s.NbrChannels))
+	buf, err := enc.NewBuffer(0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sum := md5.New()
+	for {
+		if _, err := d.r.r.Peek(1); err == io.EOF {
+			break
+		}
+		samples, bps, err := d.readFrame()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(samples) != int(s.NbrChannels) || bps != uint(s.BitsPerSample) {
+			return nil, nil, errors.New("frame format differs from STREAMINFO")
+		}
+		_, _ = buf.Write(frameBytes(samples, bps, depth, sum)) // always returns nil error
+	}
+
+	if s.MD5 != [16]byte{} && !bytes.Equal(sum.Sum(nil), s.MD5[:]) {
+		return nil, nil, errMD5
+	}
+	return buf, enc, nil
+}
+
+// frameBytes interleaves the decoded samples of a frame into the layout of a
+// pcm.Buffer, adding them to the checksum on the way.
+func frameBytes(samples [][]int64, bps uint, depth int, sum hash.Hash) []byte {
+	n := len(samples[0])
+	out := make([]byte, 0, n*len(samples)*depth)
+	raw := make([]byte, 0, cap(out))
+	shift := uint(8*depth) - bps
+	for i := 0; i < n; i++ {
+		for _, ch := range samples {
+			v := ch[i]
+			for b := 0; b < depth; b++ {
+				raw = append(raw, byte(v>>(8*uint(b))))
+			}
+			v <<= shift
+			if depth == 1 {
+				v += 0x80 // unsigned
+			}
+			for b := 0; b < depth; b++ {
+				out = append(out, byte(v>>(8*uint(b))))
+			}
+		}
+	}
+	sum.Write(raw)
+	return out
+}
+
+// readMetadata checks the "fLaC" marker and reads the metadata blocks.
+func (d *Decoder) readMetadata() error {
+	d.StreamInfo = StreamInfo{}
+	marker, err := d.r.readBits(32)
+	if err != nil {
+		return err
+	}
+	if marker != 'f'<<24|'L'<<16|'a'<<8|'C' {
+		return errNotFLAC
+	}
+
+	found := false
+	for last := false; !last; {
+		header, err := d.r.readBits(32)
+		if err != nil {
+			return err
+		}
+		last = header>>31 == 1
+		kind := header >> 24 & 0x7F
+		size := int64(header & 0xFFFFFF)
+
+		if kind == 0 {
+			if size != streamInfoSize {
+				return fmt.Errorf("STREAMINFO block has size %d", size)
+			}
+			if err := d.readStreamInfo(); err != nil {
+				return err
+			}
+			found = true
+			continue
+		}
+		if _, err := io.CopyN(io.Discard, d.r.r, size); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return errNoStreamInfo
+	}
+	return nil
+}
+
+// readStreamInfo reads the body of a STREAMINFO block.
+func (d *Decoder) readStreamInfo() error {
+	s := &d.StreamInfo
+	fields := []struct {
+		n uint
+		v func(uint64)
+	}{
+		{16, func(v uint64) { s.MinBlockSize = uint16(v) }},
+		{16, func(v uint64) { s.MaxBlockSize = uint16(v) }},
+		{24, func(v uint64) { s.MinFrameSize = uint32(v) }},
+		{24, func(v uint64) { s.MaxFrameSize = uint32(v) }},
+		{20, func(v uint64) { s.SampleRate = uint32(v) }},
+		{3, func(v uint64) { s.NbrChannels = uint8(v + 1) }},
+		{5, func(v uint64) { s.BitsPerSample = uint8(v + 1) }},
+		{36, func(v uint64) { s.TotalSamples = v }},
+	}
+	for _, f := range fields {
+		v, err := d.r.readBits(f.n)
+		if err != nil {
+			return err
+		}
+		f.v(v)
+	}
+	_, err := io.ReadFull(d.r.r, s.MD5[:])
+	return err
+}
+
+// readFrame reads one frame, returning its samples for each channel and
+// their bits per sample.
+func (d *Decoder) readFrame() ([][]int64, uint, error) {
+	r := d.r
+	r.resetCRC()
+
+	sync, err := r.readBits(15)
+	if err != nil {
+		return nil, 0, err
+	}
+	if sync != 0xFFF8>>1 {
+		return nil, 0, errBadSync
+	}
+	// The blocking strategy only changes what the coded number counts.
+	if _, err := r.readBits(1); err != nil {
+		return nil, 0, err
+	}
+
+	codes, err := r.readBits(16)
+	if err != nil {
+		return nil, 0, err
+	}
+	blockCode := codes >> 12
+	rateCode := codes >> 8 & 0xF
+	assignment := codes >> 4 & 0xF
+	sizeCode := codes >> 1 & 0x7
+	if codes&1 != 0 || blockCode == 0 || rateCode == 0xF || assignment > assignMidSide || sizeCode == 0x3 {
+		return nil, 0, errReservedValue
+	}
+	if err := d.skipUTF8(); err != nil {
+		return nil, 0, err
+	}
+
+	var n int
+	switch {
+	case blockCode == 0x1:
+		n = 192
+	case blockCode <= 0x5:
+		n = 576 << (blockCode - 2)
+	case blockCode == 0x6:
+		v, err := r.readBits(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		n = int(v) + 1
+	case blockCode == 0x7:
+		v, err := r.readBits(16)
+		if err != nil {
+			return nil, 0, err
+		}
+		n = int(v) + 1
+	default:
+		n = 256 << (blockCode - 8)
+	}
+
+	// The sample rate is only needed from STREAMINFO, but may be followed by
+	// extra bytes.
+	switch rateCode {
+	case 0xC:
+		_, err = r.readBits(8)
+	case 0xD, 0xE:
+		_, err = r.readBits(16)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bps := uint(d.StreamInfo.BitsPerSample)
+	for b, code := range sampleSizeCodes {
+		if code == sizeCode {
+			bps = b
+		}
+	}
+
+	crc := r.crc8
+	got, err := r.readBits(8)
+	if err != nil {
+		return nil, 0, err
+	}
+	if uint8(got) != crc {
+		return nil, 0, errHeaderCRC
+	}
+
+	channels := int(assignment) + 1
+	if assignment >= assignLeftSide {
+		channels = 2
+	}
+	samples := make([][]int64, channels)
+	for c := range samples {
+		// Side channels need an extra bit.
+		sbps := bps
+		if (assignment == assignLeftSide || assignment == assignMidSide) && c == 1 ||
+			assignment == assignRightSide && c == 0 {
+			sbps++
+		}
+		if samples[c], err = d.readSubframe(n, sbps); err != nil {
+			return nil, 0, err
+		}
+	}
+	r.align()
+
+	crc16 := r.crc16
+	got, err = r.readBits(16)
+	if err != nil {
+		return nil, 0, err
+	}
+	if uint16(got) != crc16 {
+		return nil, 0, errFrameCRC
+	}
+
+	decorrelate(samples, assignment)
+	return samples, bps, nil
+}
+
+// skipUTF8 reads a frame or sample number, which is coded like UTF-8.
+func (d *Decoder) skipUTF8() error {
+	lead, err := d.r.readBits(8)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for lead&(0x80>>uint(n)) != 0 {
+		n++
+	}
+	if n == 1 || n > 7 {
+		return errReservedValue
+	}
+	for i := 1; i < n; i++ {
+		b, err := d.r.readBits(8)
+		if err != nil {
+			return err
+		}
+		if b&0xC0 != 0x80 {
+			return errReservedValue
+		}
+	}
+	return nil
+}
+
+// decorrelate undoes stereo decorrelation in place.
+func decorrelate(samples [][]int64, assignment uint64) {
+	switch assignment {
+	case assignLeftSide:
+		left, side := samples[0], samples[1]
+		for i := range left {
+			side[i] = left[i] - side[i]
+		}
+	case assignRightSide:
+		side, right := samples[0], samples[1]
+		for i := range right {
+			side[i] += right[i]
+		}
+	case assignMidSide:
+		mid, side := samples[0], samples[1]
+		for i := range mid {
+			m := mid[i]<<1 | side[i]&1
+			mid[i] = (m + side[i]) >> 1
+			side[i] = (m - side[i]) >> 1
+		}
+	}
+}
+
+// readSubframe reads one channel of a frame with n samples.
+func (d *Decoder) readSubframe(n int, bps uint) ([]int64, error) {
+	r := d.r
+	header, err := r.readBits(8)
+	if err != nil {
+		return nil, err
+	}
+	if header&0x80 != 0 {
+		return nil, errReservedValue
+	}
+	kind := int(header >> 1 & 0x3F)
+
+	// Wasted bits are zero in every sample, and aren't stored.
+	var wasted uint
+	if header&1 != 0 {
+		k, err := r.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = uint(k) + 1
+		if wasted >= bps {
+			return nil, fmt.Errorf("%d wasted bits in a %d-bit subframe", wasted, bps)
+		}
+		bps -= wasted
+	}
+
+	x := make([]int64, n)
+	switch {
+	case kind == subframeConstant:
+		v, err := r.readSigned(bps)
+		if err != nil {
+			return nil, err
+		}
+		for i := range x {
+			x[i] = v
+		}
+
+	case kind == subframeVerbatim:
+		for i := range x {
+			if x[i], err = r.readSigned(bps); err != nil {
+				return nil, err
+			}
+		}
+
+	case kind >= subframeFixed && kind <= subframeFixed|maxFixedOrder:
+		order := kind - subframeFixed
+		if err := d.readWarmup(x, order, bps); err != nil {
+			return nil, err
+		}
+		if err := d.readResidual(x, order); err != nil {
+			return nil, err
+		}
+		fixedRestore(x, order)
+
+	case kind >= subframeLPC:
+		order := kind - subframeLPC + 1
+		if err := d.readWarmup(x, order, bps); err != nil {
+			return nil, err
+		}
+		precision, err := r.readBits(4)
+		if err != nil {
+			return nil, err
+		}
+		if precision == 0xF {
+			return nil, errReservedValue
+		}
+		shift, err := r.readSigned(5)
+		if err != nil {
+			return nil, err
+		}
+		if shift < 0 {
+			return nil, fmt.Errorf("negative LPC shift: %d", shift)
+		}
+		coefs := make([]int32, order)
+		for i := range coefs {
+			c, err := r.readSigned(uint(precision) + 1)
+			if err != nil {
+				return nil, err
+			}
+			coefs[i] = int32(c)
+		}
+		if err := d.readResidual(x, order); err != nil {
+			return nil, err
+		}
+		lpcRestore(x, coefs, int(shift))
+
+	default:
+		return nil, errReservedValue
+	}
+
+	if wasted > 0 {
+		for i := range x {
+			x[i] <<= wasted
+		}
+	}
+	return x, nil
+}
+
+// readWarmup reads the unpredicted samples which start a subframe.
+func (d *Decoder) readWarmup(x []int64, order int, bps uint) error {
+	if order > len(x) {
+		return fmt.Errorf("predictor order %d exceeds block size %d", order, len(x))
+	}
+	for i := 0; i < order; i++ {
+		v, err := d.r.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		x[i] = v
+	}
+	return nil
+}
+
+// readResidual reads a partitioned Rice-coded residual into x, after the
+// warmup samples of the predictor.
+func (d *Decoder) readResidual(x []int64, predOrder int) error {
+	r := d.r
+	method, err := r.readBits(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errReservedValue
+	}
+	paramBits, escape := uint(4), uint64(0xF)
+	if method == 1 {
+		paramBits, escape = 5, 0x1F
+	}
+
+	order, err := r.readBits(4)
+	if err != nil {
+		return err
+	}
+	parts := 1 << order
+	partLen := len(x) >> order
+	if len(x)%parts != 0 || partLen < predOrder {
+		return fmt.Errorf("invalid partition order %d", order)
+	}
+
+	i := predOrder
+	for p := 0; p < parts; p++ {
+		end := (p + 1) * partLen
+		k, err := r.readBits(paramBits)
+		if err != nil {
+			return err
+		}
+
+		if k == escape {
+			// The partition is stored as plain signed values.
+			size, err := r.readBits(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if x[i], err = r.readSigned(uint(size)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			if x[i], err = r.readRice(uint(k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Package flac allows reading and writing PCM audio data losslessly
+// compressed in FLAC files.
+package flac
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+// StreamInfo holds the fields of the STREAMINFO metadata block, which every
+// FLAC file begins with. Source: RFC 9639.
+type StreamInfo struct {
+	MinBlockSize  uint16 // in samples, excluding the last block
+	MaxBlockSize  uint16
+	MinFrameSize  uint32 // in bytes; 0 if unknown
+	MaxFrameSize  uint32
+	SampleRate    uint32
+	NbrChannels   uint8
+	BitsPerSample uint8
+	TotalSamples  uint64 // per channel; 0 if unknown
+	MD5           [16]byte
+}
+
+const (
+	// blockSize is the number of samples per channel in each frame.
+	blockSize = 4096
+	// streamInfoSize is the size of the body of a STREAMINFO block.
+	streamInfoSize = 34
+)
+
+var (
+	errFloat     = errors.New("FLAC cannot hold float samples")
+	errCompanded = errors.New("FLAC cannot hold G.711 samples")
+)
+
+type encoder struct {
+	NbrChannels int
+	Frequency   int
+	ByteDepth   int
+}
+
+// NewEncoder creates a new encoder, which describes the format of the audio
+// samples to be encoded. The samples are taken in the same little-endian
+// layout as a pcm.Buffer, with 8-bit samples unsigned.
+func NewEncoder(nbrChannels, byteDepth, frequency int) *encoder {
+	return &encoder{
+		NbrChannels: nbrChannels,
+		Frequency:   frequency,
+		ByteDepth:   byteDepth,
+	}
+}
+
+// NewEncoderFor creates a new encoder for samples produced by a pcm.Encoder.
+// FLAC only holds linear integer samples, so float and G.711 formats are
+// rejected.
+func NewEncoderFor(enc *pcm.Encoder) (*encoder, error) {
+	if enc.Format.IsFloat() {
+		return nil, errFloat
+	}
+	if enc.Format.IsCompanded() {
+		return nil, errCompanded
+	}
+	return NewEncoder(enc.Channels, enc.Depth, enc.Rate), nil
+}
+
+// Encode takes an io.Reader and returns a buffer containing a FLAC file.
+func (e *encoder) Encode(r io.Reader) ([]byte, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	w, err := e.NewWriter(&out, int64(len(buf)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(buf); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	// The whole file is in memory, so fill in the checksum and frame sizes
+	// which a Writer can't go back for.
+	data := out.Bytes()
+	copy(data, w.info.encode())
+	return data, nil
+}
+
+// validate checks that the format can be stored in a FLAC file.
+func (e *encoder) validate() error {
+	if e.NbrChannels < 1 || e.NbrChannels > 8 {
+		return fmt.Errorf("unsupported channel count: %d", e.NbrChannels)
+	}
+	if e.ByteDepth < 1 || e.ByteDepth > 4 {
+		return fmt.Errorf("unsupported byte depth: %d", e.ByteDepth)
+	}
+	if e.Frequency < 1 || e.Frequency >= 1<<20 {
+		return fmt.Errorf("unsupported sample rate: %d", e.Frequency)
+	}
+	return nil
+}
+
+// streamInfo returns the STREAMINFO block for the given number of bytes of
+// samples, which may be 0 if unknown.
+func (e *encoder) streamInfo(dataSize uint64) StreamInfo {
+	return StreamInfo{
+		MinBlockSize:  blockSize,
+		MaxBlockSize:  blockSize,
+		SampleRate:    uint32(e.Frequency),
+		NbrChannels:   uint8(e.NbrChannels),
+		BitsPerSample: uint8(8 * e.ByteDepth),
+		TotalSamples:  dataSize / uint64(e.NbrChannels*e.ByteDepth),
+	}
+}
+
+// encode returns the "fLaC" marker followed by the STREAMINFO block, marked
+// as the last metadata block.
+func (s *StreamInfo) encode() []byte {
+	var w bitWriter
+	w.writeBits('f'<<24|'L'<<16|'a'<<8|'C', 32)
+	w.writeBits(1, 1) // last metadata block
+	w.writeBits(0, 7) // STREAMINFO
+	w.writeBits(streamInfoSize, 24)
+
+	w.writeBits(uint64(s.MinBlockSize), 16)
+	w.writeBits(uint64(s.MaxBlockSize), 16)
+	w.writeBits(uint64(s.MinFrameSize), 24)
+	w.writeBits(uint64(s.MaxFrameSize), 24)
+	w.writeBits(uint64(s.SampleRate), 20)
+	w.writeBits(uint64(s.NbrChannels-1), 3)
+	w.writeBits(uint64(s.BitsPerSample-1), 5)
+	w.writeBits(s.TotalSamples, 36)
+	return append(w.bytes(), s.MD5[:]...)
+}
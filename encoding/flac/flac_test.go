@@ -0,0 +1,260 @@
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chaimleib/synth/internal/seekbuf"
+	"github.com/chaimleib/synth/pcm"
+)
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		enc    *pcm.Encoder
+		signal string
+		// d is long enough for a short final block after full ones.
+		d time.Duration
+	}{
+		{pcm.New(8000, 1, 1), "sine", time.Second},
+		{pcm.New(44100, 2, 1), "sine", 200 * time.Millisecond},
+		{pcm.New(44100, 2, 2), "sine", 200 * time.Millisecond},
+		{pcm.New(44100, 2, 2), "noise", 200 * time.Millisecond},
+		{pcm.New(44100, 2, 2), "silence", 200 * time.Millisecond},
+		{pcm.New(48000, 3, 2), "sine", 200 * time.Millisecond},
+		{pcm.New(48000, 4, 1), "noise", 100 * time.Millisecond},
+		{pcm.New(22050, 2, 6), "sine", 100 * time.Millisecond},
+		{pcm.New(44100, 2, 2), "sine", time.Millisecond},
+	}
+	for _, c := range cases {
+		c := c
+		name := fmt.Sprintf(
+			"rate=%d&channels=%d&depth=%d&signal=%s&d=%v",
+			c.enc.Rate, c.enc.Channels, c.enc.Depth, c.signal, c.d,
+		)
+		t.Run(name, func(t *testing.T) {
+			var (
+				src *pcm.Buffer
+				err error
+			)
+			switch c.signal {
+			case "sine":
+				src, err = c.enc.Sine(c.d, 440, 0.5, 0)
+			case "noise":
+				src, err = c.enc.WhiteNoise(c.d, 0.9)
+			case "silence":
+				src, err = c.enc.NewSilence(c.d)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := NewEncoderFor(c.enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := e.Encode(bytes.NewReader(src.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := NewDecoder(bytes.NewReader(file))
+			buf, gotEnc, err := d.Decode()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *gotEnc != *c.enc {
+				t.Errorf("%+v (got) != %+v (expected)", *gotEnc, *c.enc)
+			}
+			if !bytes.Equal(buf.Bytes(), src.Bytes()) {
+				t.Errorf("decoded samples differ from encoded samples")
+			}
+			samples := uint64(src.Len() / (c.enc.Channels * c.enc.Depth))
+			if got := d.StreamInfo.TotalSamples; got != samples {
+				t.Errorf("%d (got) != %d (expected) total samples", got, samples)
+			}
+			if c.signal != "noise" && len(file) >= src.Len() {
+				t.Errorf("%d (got) >= %d bytes uncompressed", len(file), src.Len())
+			}
+		})
+	}
+}
+
+func TestFloatRejected(t *testing.T) {
+	if _, err := NewEncoderFor(pcm.NewFloat(44100, 4, 2)); err == nil {
+		t.Error("expected an error for float samples")
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	enc := pcm.New(44100, 2, 1)
+	src, err := enc.Sine(50*time.Millisecond, 440, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := NewEncoderFor(enc)
+	file, err := e.Encode(bytes.NewReader(src.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file[len(file)-10] ^= 0x01
+	if _, _, err := Decode(bytes.NewReader(file)); err == nil {
+		t.Error("expected an error for a corrupt frame")
+	}
+}
+
+// TestDecodeReference decodes a file made by FFmpeg's FLAC encoder, and
+// compares it with the PCM from another decoder. See testdata/README.md.
+func TestDecodeReference(t *testing.T) {
+	f, err := os.Open("testdata/ffmpeg.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	buf, enc, err := Decode(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := pcm.New(8000, 2, 1); *enc != *expected {
+		t.Errorf("%+v (got) != %+v (expected)", *enc, *expected)
+	}
+	if got, expected := buf.SampleLen(), 21312; got != expected {
+		t.Errorf("%d (got) != %d (expected) samples", got, expected)
+	}
+	sum := md5.Sum(buf.Bytes())
+	if got, expected := hex.EncodeToString(sum[:]), "3133c736f37f1bd0c8b32e5044d2d3ac"; got != expected {
+		t.Errorf("%s (got) != %s (expected) MD5 of the samples", got, expected)
+	}
+}
+
+// crcBits computes a CRC of p a bit at a time, as a check on the tables
+// used by the encoder and decoder.
+func crcBits(p []byte, width uint, poly uint32) uint32 {
+	top := uint32(1) << (width - 1)
+	mask := top<<1 - 1
+	var c uint32
+	for _, b := range p {
+		c ^= uint32(b) << (width - 8)
+		for i := 0; i < 8; i++ {
+			if c&top != 0 {
+				c = c<<1 ^ poly
+			} else {
+				c <<= 1
+			}
+		}
+		c &= mask
+	}
+	return c
+}
+
+// TestChecksums checks the MD5 and CRCs of an encoded file against values
+// computed independently, over enough frames for frame numbers of two bytes.
+func TestChecksums(t *testing.T) {
+	// The standard check values of CRC-8 and CRC-16/UMTS.
+	check := []byte("123456789")
+	if got := crcBits(check, 8, 0x07); got != 0xF4 || uint32(crc8(check)) != got {
+		t.Errorf("%#x, %#x (got) != 0xf4 (expected) CRC-8", got, crc8(check))
+	}
+	if got := crcBits(check, 16, 0x8005); got != 0xFEE8 || uint32(crc16(check)) != got {
+		t.Errorf("%#x, %#x (got) != 0xfee8 (expected) CRC-16", got, crc16(check))
+	}
+
+	enc := pcm.New(8000, 2, 1)
+	src, err := enc.Sine(67*time.Second, 440, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := NewEncoderFor(enc)
+	file, err := e.Encode(bytes.NewReader(src.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// STREAMINFO is the first metadata block, after the marker and the block
+	// header. Its MD5 is in the last 16 bytes.
+	if got, expected := file[8+18:8+34], md5.Sum(src.Bytes()); !bytes.Equal(got, expected[:]) {
+		t.Errorf("%x (got) != %x (expected) MD5", got, expected)
+	}
+
+	// Find the frames with the Decoder, but check them by hand.
+	r := bytes.NewReader(file)
+	d := NewDecoder(r)
+	if err := d.readMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	offset := func() int { return len(file) - r.Len() - d.r.r.Buffered() }
+	frames := 0
+	for start := offset(); start < len(file); start = offset() {
+		if _, _, err := d.readFrame(); err != nil {
+			t.Fatalf("frame %d: %v", frames, err)
+		}
+		frame := file[start:offset()]
+
+		number, size := utf8.DecodeRune(frame[4:])
+		if int(number) != frames {
+			t.Errorf("frame %d: %d (got) != %d (expected) frame number", frames, number, frames)
+		}
+		header := 4 + size
+		switch frame[2] >> 4 {
+		case 0x6:
+			header++
+		case 0x7:
+			header += 2
+		}
+		if got, expected := uint32(frame[header]), crcBits(frame[:header], 8, 0x07); got != expected {
+			t.Errorf("frame %d: %#x (got) != %#x (expected) header CRC", frames, got, expected)
+		}
+		crc := frame[len(frame)-2:]
+		if got, expected := uint32(binary.BigEndian.Uint16(crc)), crcBits(frame[:len(frame)-2], 16, 0x8005); got != expected {
+			t.Errorf("frame %d: %#x (got) != %#x (expected) frame CRC", frames, got, expected)
+		}
+		frames++
+	}
+	if expected := (src.SampleLen() + blockSize - 1) / blockSize; frames != expected {
+		t.Errorf("%d (got) != %d (expected) frames", frames, expected)
+	}
+}
+
+func TestWriterSeekable(t *testing.T) {
+	enc := pcm.New(44100, 2, 2)
+	src, err := enc.Sine(200*time.Millisecond, 440, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _ := NewEncoderFor(enc)
+
+	expected, err := e.Encode(bytes.NewReader(src.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out seekbuf.Buffer
+	w, err := e.NewWriter(&out, UnknownSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in pieces which don't line up with samples or blocks.
+	data := src.Bytes()
+	for len(data) > 0 {
+		n := 1001
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Errorf("streamed file differs from encoded file")
+	}
+}
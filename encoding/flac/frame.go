@@ -0,0 +1,441 @@
+package flac
+
+import "math"
+
+// Channel assignments for stereo decorrelation. Values below these give the
+// number of independent channels, minus one.
+const (
+	assignLeftSide  = 8
+	assignRightSide = 9
+	assignMidSide   = 10
+)
+
+// Subframe types, as stored in the subframe header.
+const (
+	subframeConstant = 0x00
+	subframeVerbatim = 0x01
+	subframeFixed    = 0x08 // | order
+	subframeLPC      = 0x20 // | order-1
+)
+
+const (
+	maxFixedOrder     = 4
+	maxPartitionOrder = 8
+	// maxRiceParam is the largest parameter of the 4-bit Rice method; 5-bit
+	// Rice2 allows up to maxRice2Param. The next value is an escape code.
+	maxRiceParam  = 14
+	maxRice2Param = 30
+)
+
+// sampleRateCodes are the frame header codes for common sample rates.
+// Others are read from STREAMINFO.
+var sampleRateCodes = map[int]uint64{
+	88200:  0x1,
+	176400: 0x2,
+	192000: 0x3,
+	8000:   0x4,
+	16000:  0x5,
+	22050:  0x6,
+	24000:  0x7,
+	32000:  0x8,
+	44100:  0x9,
+	48000:  0xA,
+	96000:  0xB,
+}
+
+// sampleSizeCodes are the frame header codes for bits per sample. Others are
+// read from STREAMINFO.
+var sampleSizeCodes = map[uint]uint64{
+	8:  0x1,
+	12: 0x2,
+	16: 0x4,
+	20: 0x5,
+	24: 0x6,
+	32: 0x7,
+}
+
+// encodeFrame encodes one block of samples, given per channel, as a frame.
+func encodeFrame(samples [][]int64, bps uint, rate int, frameNum uint64) []byte {
+	blockSize := len(samples[0])
+	window := tukey(blockSize)
+
+	// Pick the cheapest stereo decorrelation.
+	assignment := uint64(len(samples) - 1)
+	channels := samples
+	channelBPS := make([]uint, len(samples))
+	for i := range channelBPS {
+		channelBPS[i] = bps
+	}
+	var plans []subframe
+	if len(samples) == 2 {
+		left, right := samples[0], samples[1]
+		mid := make([]int64, blockSize)
+		side := make([]int64, blockSize)
+		for i := range left {
+			mid[i] = (left[i] + right[i]) >> 1
+			side[i] = left[i] - right[i]
+		}
+		l := planSubframe(left, bps, window)
+		r := planSubframe(right, bps, window)
+		m := planSubframe(mid, bps, window)
+		s := planSubframe(side, bps+1, window)
+
+		options := []struct {
+			assignment uint64
+			plans      []subframe
+			channels   [][]int64
+			bps        []uint
+		}{
+			{1, []subframe{l, r}, [][]int64{left, right}, []uint{bps, bps}},
+			{assignLeftSide, []subframe{l, s}, [][]int64{left, side}, []uint{bps, bps + 1}},
+			{assignRightSide, []subframe{s, r}, [][]int64{side, right}, []uint{bps + 1, bps}},
+			{assignMidSide, []subframe{m, s}, [][]int64{mid, side}, []uint{bps, bps + 1}},
+		}
+		best := options[0]
+		for _, o := range options[1:] {
+			if o.plans[0].bits+o.plans[1].bits < best.plans[0].bits+best.plans[1].bits {
+				best = o
+			}
+		}
+		assignment, plans, channels, channelBPS = best.assignment, best.plans, best.channels, best.bps
+	} else {
+		for _, x := range samples {
+			plans = append(plans, planSubframe(x, bps, window))
+		}
+	}
+
+	var w bitWriter
+	writeFrameHeader(&w, blockSize, rate, assignment, bps, frameNum)
+	for i, p := range plans {
+		p.write(&w, channels[i], channelBPS[i])
+	}
+	w.align()
+	crc := crc16(w.bytes())
+	w.writeBits(uint64(crc), 16)
+	return w.bytes()
+}
+
+// writeFrameHeader writes a frame header, including its CRC-8.
+func writeFrameHeader(w *bitWriter, blockSize, rate int, assignment uint64, bps uint, frameNum uint64) {
+	start := len(w.buf)
+	w.writeBits(0xFFF8, 16) // sync code, and fixed block size strategy
+
+	var blockCode uint64
+	switch {
+	case blockSize == 192:
+		blockCode = 0x1
+	case blockSize == 4096:
+		blockCode = 0xC
+	case blockSize <= 256:
+		blockCode = 0x6
+	default:
+		blockCode = 0x7
+	}
+	w.writeBits(blockCode, 4)
+	rateCode := sampleRateCodes[rate]
+	w.writeBits(rateCode, 4)
+	w.writeBits(assignment, 4)
+	w.writeBits(sampleSizeCodes[bps], 3)
+	w.writeBits(0, 1) // reserved
+
+	writeUTF8(w, frameNum)
+	switch blockCode {
+	case 0x6:
+		w.writeBits(uint64(blockSize-1), 8)
+	case 0x7:
+		w.writeBits(uint64(blockSize-1), 16)
+	}
+	w.writeBits(uint64(crc8(w.buf[start:])), 8)
+}
+
+// writeUTF8 writes a frame number with the variable-length coding of UTF-8,
+// extended to 36 bits.
+func writeUTF8(w *bitWriter, v uint64) {
+	if v < 0x80 {
+		w.writeBits(v, 8)
+		return
+	}
+	// Find how many continuation bytes are needed.
+	n := 1
+	for v >= 1<<(6*uint(n)+uint(6-n)) {
+		n++
+	}
+	lead := uint64(0xFF00>>uint(n+1)) & 0xFF
+	w.writeBits(lead|v>>(6*uint(n)), 8)
+	for i := n - 1; i >= 0; i-- {
+		w.writeBits(0x80|(v>>(6*uint(i)))&0x3F, 8)
+	}
+}
+
+// subframe is a plan for encoding one channel of a block.
+type subframe struct {
+	kind  int
+	order int
+	// coefs and shift are for LPC subframes.
+	coefs    []int32
+	shift    int
+	residual []int64
+	rice     ricePlan
+	// bits is the encoded size of the subframe.
+	bits int
+}
+
+// planSubframe finds a compact way to encode one channel of a block.
+func planSubframe(x []int64, bps uint, window []float64) subframe {
+	n := len(x)
+	best := subframe{kind: subframeVerbatim, bits: 8 + n*int(bps)}
+
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		return subframe{kind: subframeConstant, bits: 8 + int(bps)}
+	}
+
+	// Estimate the best fixed predictor cheaply, and then plan it fully.
+	bestFixed := -1
+	bestEstimate := math.MaxInt
+	residual := make([]int64, n)
+	for order := 0; order <= maxFixedOrder && order < n; order++ {
+		if !fixedResidual(x, order, residual) {
+			continue
+		}
+		if e := order*int(bps) + estimateRice(residual[:n-order]); e < bestEstimate {
+			bestFixed, bestEstimate = order, e
+		}
+	}
+	if bestFixed >= 0 {
+		res := make([]int64, n-bestFixed)
+		fixedResidual(x, bestFixed, res)
+		plan := planResidual(res, bestFixed, n)
+		sf := subframe{
+			kind:     subframeFixed,
+			order:    bestFixed,
+			residual: res,
+			rice:     plan,
+			bits:     8 + bestFixed*int(bps) + plan.bits,
+		}
+		if sf.bits < best.bits {
+			best = sf
+		}
+	}
+
+	// Likewise for linear prediction.
+	maxOrder := maxLPCOrder
+	if maxOrder >= n {
+		maxOrder = n - 1
+	}
+	if maxOrder < 1 {
+		return best
+	}
+	r := autocorrelation(x, window, maxOrder)
+	var (
+		bestLPC   subframe
+		lpcBest   = math.MaxInt
+		candidate = make([]int64, n)
+	)
+	for _, coefs := range levinson(r, maxOrder) {
+		order := len(coefs)
+		q, shift, ok := quantize(coefs, lpcPrecision)
+		if !ok || !lpcResidual(x, q, shift, candidate) {
+			continue
+		}
+		header := order*int(bps) + 4 + 5 + order*lpcPrecision
+		if e := header + estimateRice(candidate[:n-order]); e < lpcBest {
+			lpcBest = e
+			bestLPC = subframe{
+				kind:     subframeLPC,
+				order:    order,
+				coefs:    q,
+				shift:    shift,
+				residual: append([]int64(nil), candidate[:n-order]...),
+				bits:     8 + header,
+			}
+		}
+	}
+	if bestLPC.kind == subframeLPC {
+		bestLPC.rice = planResidual(bestLPC.residual, bestLPC.order, n)
+		bestLPC.bits += bestLPC.rice.bits
+		if bestLPC.bits < best.bits {
+			best = bestLPC
+		}
+	}
+	return best
+}
+
+// write encodes the subframe for the samples x.
+func (sf *subframe) write(w *bitWriter, x []int64, bps uint) {
+	switch sf.kind {
+	case subframeConstant:
+		w.writeBits(subframeConstant<<1, 8)
+		w.writeSigned(x[0], bps)
+
+	case subframeVerbatim:
+		w.writeBits(subframeVerbatim<<1, 8)
+		for _, v := range x {
+			w.writeSigned(v, bps)
+		}
+
+	case subframeFixed:
+		w.writeBits(uint64(subframeFixed|sf.order)<<1, 8)
+		for _, v := range x[:sf.order] {
+			w.writeSigned(v, bps)
+		}
+		sf.rice.write(w, sf.residual, sf.order)
+
+	case subframeLPC:
+		w.writeBits(uint64(subframeLPC|(sf.order-1))<<1, 8)
+		for _, v := range x[:sf.order] {
+			w.writeSigned(v, bps)
+		}
+		w.writeBits(lpcPrecision-1, 4)
+		w.writeSigned(int64(sf.shift), 5)
+		for _, c := range sf.coefs {
+			w.writeSigned(int64(c), lpcPrecision)
+		}
+		sf.rice.write(w, sf.residual, sf.order)
+	}
+}
+
+// ricePlan describes how a residual is split into Rice-coded partitions.
+type ricePlan struct {
+	order  uint   // partition order: there are 1<<order partitions
+	params []uint // Rice parameter of each partition
+	rice2  bool   // whether the 5-bit parameter method is needed
+	bits   int    // encoded size, including the method and order fields
+}
+
+// estimateRice guesses the number of bits needed to Rice-code a residual as
+// a single partition.
+func estimateRice(residual []int64) int {
+	if len(residual) == 0 {
+		return 0
+	}
+	var sum uint64
+	for _, r := range residual {
+		sum += zigzag(r)
+	}
+	k := riceParam(sum, len(residual))
+	return len(residual)*(int(k)+1) + int(sum>>k)
+}
+
+// riceParam guesses the best Rice parameter for n values summing to sum.
+func riceParam(sum uint64, n int) uint {
+	mean := sum / uint64(n)
+	var k uint
+	for k < maxRice2Param && mean>>(k+1) > 0 {
+		k++
+	}
+	return k
+}
+
+// planResidual picks the partition order and Rice parameters which encode
+// a residual most compactly. The residual excludes the predictor's warmup
+// samples, of which there are predOrder in a block of blockSize.
+func planResidual(residual []int64, predOrder, blockSize int) ricePlan {
+	u := make([]uint64, len(residual))
+	for i, r := range residual {
+		u[i] = zigzag(r)
+	}
+
+	best := ricePlan{bits: math.MaxInt}
+	for order := uint(0); order <= maxPartitionOrder; order++ {
+		parts := 1 << order
+		if blockSize%parts != 0 || blockSize>>order <= predOrder {
+			break
+		}
+		plan := ricePlan{order: order, params: make([]uint, parts), bits: 2 + 4}
+		start := 0
+		for p := 0; p < parts; p++ {
+			end := start + blockSize>>order
+			if p == 0 {
+				end -= predOrder
+			}
+			k, bits := bestRiceParam(u[start:end])
+			plan.params[p] = k
+			plan.bits += bits
+			if k > maxRiceParam {
+				plan.rice2 = true
+			}
+			start = end
+		}
+		// Each partition stores its parameter in 4 or 5 bits.
+		paramBits := 4
+		if plan.rice2 {
+			paramBits = 5
+		}
+		plan.bits += parts * paramBits
+		if plan.bits < best.bits {
+			best = plan
+		}
+	}
+	return best
+}
+
+// bestRiceParam returns the Rice parameter which encodes the zigzagged
+// values u most compactly, and the resulting number of bits.
+func bestRiceParam(u []uint64) (uint, int) {
+	if len(u) == 0 {
+		return 0, 0
+	}
+	var sum uint64
+	for _, v := range u {
+		sum += v
+	}
+	guess := riceParam(sum, len(u))
+
+	bestK, bestBits := guess, math.MaxInt
+	for k := guess; k <= guess+1 && k <= maxRice2Param; k++ {
+		if k > guess && guess == 0 {
+			continue
+		}
+		bits := len(u) * (int(k) + 1)
+		for _, v := range u {
+			bits += int(v >> k)
+		}
+		if bits < bestBits {
+			bestK, bestBits = k, bits
+		}
+	}
+	if guess > 0 {
+		bits := len(u) * int(guess)
+		for _, v := range u {
+			bits += int(v >> (guess - 1))
+		}
+		if bits < bestBits {
+			bestK, bestBits = guess-1, bits
+		}
+	}
+	return bestK, bestBits
+}
+
+// write encodes the residual following the plan. The first partition is
+// shorter than the others by the predictor order.
+func (p *ricePlan) write(w *bitWriter, residual []int64, predOrder int) {
+	paramBits := uint(4)
+	if p.rice2 {
+		w.writeBits(1, 2)
+		paramBits = 5
+	} else {
+		w.writeBits(0, 2)
+	}
+	w.writeBits(uint64(p.order), 4)
+
+	partLen := (len(residual) + predOrder) >> p.order
+	start := 0
+	for i, k := range p.params {
+		end := start + partLen
+		if i == 0 {
+			end -= predOrder
+		}
+		w.writeBits(uint64(k), paramBits)
+		for _, r := range residual[start:end] {
+			w.writeRice(r, k)
+		}
+		start = end
+	}
+}
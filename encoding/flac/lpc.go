@@ -0,0 +1,196 @@
+package flac
+
+import "math"
+
+const (
+	maxLPCOrder = 12
+	// lpcPrecision is the number of bits in each quantized coefficient.
+	lpcPrecision = 14
+	maxLPCShift  = 15
+)
+
+// autocorrelation returns the autocorrelation of the windowed samples for
+// lags 0 through maxLag.
+func autocorrelation(x []int64, window []float64, maxLag int) []float64 {
+	w := make([]float64, len(x))
+	for i, v := range x {
+		w[i] = float64(v) * window[i]
+	}
+	r := make([]float64, maxLag+1)
+	for lag := 0; lag <= maxLag; lag++ {
+		var sum float64
+		for i := lag; i < len(w); i++ {
+			sum += w[i] * w[i-lag]
+		}
+		r[lag] = sum
+	}
+	return r
+}
+
+// levinson solves for the linear prediction coefficients of each order up
+// to maxOrder from the autocorrelation r, using the Levinson-Durbin
+// recursion. Entry n-1 of the result holds the n coefficients of order n.
+// Orders beyond which the recursion becomes unstable are omitted.
+func levinson(r []float64, maxOrder int) [][]float64 {
+	var orders [][]float64
+	if r[0] == 0 {
+		return orders
+	}
+	coefs := make([]float64, maxOrder)
+	err := r[0]
+	for m := 0; m < maxOrder; m++ {
+		acc := r[m+1]
+		for j := 0; j < m; j++ {
+			acc -= coefs[j] * r[m-j]
+		}
+		k := acc / err
+		if math.IsNaN(k) || math.IsInf(k, 0) || math.Abs(k) >= 1 {
+			break
+		}
+
+		next := make([]float64, m+1)
+		for j := 0; j < m; j++ {
+			next[j] = coefs[j] - k*coefs[m-1-j]
+		}
+		next[m] = k
+		copy(coefs, next)
+		err *= 1 - k*k
+
+		orders = append(orders, next)
+		if err <= 0 {
+			break
+		}
+	}
+	return orders
+}
+
+// quantize converts prediction coefficients to integers with the given
+// precision, returning them along with the right shift to apply to their
+// weighted sum. It returns false if the coefficients can't be represented.
+func quantize(coefs []float64, precision uint) ([]int32, int, bool) {
+	var cmax float64
+	for _, c := range coefs {
+		cmax = math.Max(cmax, math.Abs(c))
+	}
+	if cmax == 0 {
+		return nil, 0, false
+	}
+
+	// Scale so that the largest coefficient just fits in precision-1 bits.
+	_, exp := math.Frexp(cmax)
+	shift := int(precision) - 1 - exp
+	if shift > maxLPCShift {
+		shift = maxLPCShift
+	}
+	if shift < 0 {
+		return nil, 0, false
+	}
+
+	qmax := int64(1)<<(precision-1) - 1
+	qmin := -qmax - 1
+	q := make([]int32, len(coefs))
+	var carry float64 // feeds each rounding error into the next coefficient
+	for i, c := range coefs {
+		v := c*float64(int64(1)<<shift) + carry
+		r := int64(math.Round(v))
+		if r > qmax {
+			r = qmax
+		} else if r < qmin {
+			r = qmin
+		}
+		carry = v - float64(r)
+		q[i] = int32(r)
+	}
+	return q, shift, true
+}
+
+// lpcResidual computes the residual of predicting x with the quantized
+// coefficients. The first len(q) samples are warmup, and have no residual.
+// It returns false if a residual doesn't fit in 32 bits.
+func lpcResidual(x []int64, q []int32, shift int, residual []int64) bool {
+	order := len(q)
+	for i := order; i < len(x); i++ {
+		var sum int64
+		for j, c := range q {
+			sum += int64(c) * x[i-1-j]
+		}
+		r := x[i] - sum>>shift
+		if r > math.MaxInt32 || r < math.MinInt32 {
+			return false
+		}
+		residual[i-order] = r
+	}
+	return true
+}
+
+// fixedResidual computes the residual of the fixed polynomial predictor of
+// the given order. It returns false if a residual doesn't fit in 32 bits.
+func fixedResidual(x []int64, order int, residual []int64) bool {
+	for i := order; i < len(x); i++ {
+		var r int64
+		switch order {
+		case 0:
+			r = x[i]
+		case 1:
+			r = x[i] - x[i-1]
+		case 2:
+			r = x[i] - 2*x[i-1] + x[i-2]
+		case 3:
+			r = x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+		case 4:
+			r = x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+		}
+		if r > math.MaxInt32 || r < math.MinInt32 {
+			return false
+		}
+		residual[i-order] = r
+	}
+	return true
+}
+
+// fixedRestore undoes fixedResidual in place: x holds the warmup samples
+// followed by the residual, and is overwritten with the samples.
+func fixedRestore(x []int64, order int) {
+	for i := order; i < len(x); i++ {
+		switch order {
+		case 1:
+			x[i] += x[i-1]
+		case 2:
+			x[i] += 2*x[i-1] - x[i-2]
+		case 3:
+			x[i] += 3*x[i-1] - 3*x[i-2] + x[i-3]
+		case 4:
+			x[i] += 4*x[i-1] - 6*x[i-2] + 4*x[i-3] - x[i-4]
+		}
+	}
+}
+
+// lpcRestore undoes lpcResidual in place: x holds the warmup samples
+// followed by the residual, and is overwritten with the samples.
+func lpcRestore(x []int64, q []int32, shift int) {
+	for i := len(q); i < len(x); i++ {
+		var sum int64
+		for j, c := range q {
+			sum += int64(c) * x[i-1-j]
+		}
+		x[i] += sum >> shift
+	}
+}
+
+// tukey returns a Tukey window of length n with half of its length tapered.
+func tukey(n int) []float64 {
+	const p = 0.5
+	w := make([]float64, n)
+	taper := int(p / 2 * float64(n))
+	for i := range w {
+		switch {
+		case taper > 0 && i < taper:
+			w[i] = 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(taper))
+		case taper > 0 && i >= n-taper:
+			w[i] = 0.5 - 0.5*math.Cos(math.Pi*float64(n-1-i)/float64(taper))
+		default:
+			w[i] = 1
+		}
+	}
+	return w
+}
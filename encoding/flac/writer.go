@@ -0,0 +1,224 @@
+package flac
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// UnknownSize may be passed to NewWriter when the amount of audio is not known
+// in advance. The destination must then be an io.WriteSeeker.
+const UnknownSize = -1
+
+var (
+	errNeedSeeker = errors.New("unknown data size requires an io.WriteSeeker")
+	errClosed     = errors.New("write to closed Writer")
+)
+
+// Writer streams audio samples into a FLAC file, compressing a block at a
+// time, without holding the whole file in memory.
+type Writer struct {
+	w     io.Writer
+	info  StreamInfo
+	depth int
+
+	// start is where the file begins in a seekable destination.
+	start int64
+	// size is the promised number of bytes of samples, or UnknownSize.
+	size    int64
+	written int64
+	// pending holds the samples of the block being filled.
+	pending  []byte
+	frameNum uint64
+	md5      hash.Hash
+	closed   bool
+}
+
+var _ io.WriteCloser = (*Writer)(nil)
+
+// NewWriter writes a FLAC header to w and returns a Writer for the samples.
+//
+// If w is an io.WriteSeeker, dataSize may be UnknownSize, and the header is
+// patched with the sample count, frame sizes and MD5 checksum on Close.
+// Otherwise, dataSize must be the exact number of bytes of samples that will
+// be written, and the header is left without a checksum.
+func (e *encoder) NewWriter(w io.Writer, dataSize int64) (*Writer, error) {
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	if dataSize < UnknownSize {
+		return nil, fmt.Errorf("invalid data size: %d", dataSize)
+	}
+
+	ww := &Writer{
+		w:     w,
+		depth: e.ByteDepth,
+		size:  dataSize,
+		md5:   md5.New(),
+	}
+	if ws, ok := w.(io.WriteSeeker); ok {
+		start, err := ws.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		ww.start = start
+	} else if dataSize == UnknownSize {
+		return nil, errNeedSeeker
+	}
+
+	var headerSize uint64
+	if dataSize != UnknownSize {
+		headerSize = uint64(dataSize)
+	}
+	ww.info = e.streamInfo(headerSize)
+	if _, err := w.Write(ww.info.encode()); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// Write appends audio samples, encoded as in a pcm.Buffer, to the file.
+// Frames are written as blocks fill up.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.size != UnknownSize && w.written+int64(len(p)) > w.size {
+		return 0, fmt.Errorf("wrote more than the promised %d bytes", w.size)
+	}
+	w.written += int64(len(p))
+
+	n := len(p)
+	frame := blockSize * w.frameLen()
+	for len(p) > 0 {
+		m := frame - len(w.pending)
+		if m > len(p) {
+			m = len(p)
+		}
+		w.pending = append(w.pending, p[:m]...)
+		p = p[m:]
+		if len(w.pending) == frame {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// frameLen returns the number of bytes in one sample of every channel.
+func (w *Writer) frameLen() int {
+	return int(w.info.NbrChannels) * w.depth
+}
+
+// flush encodes the pending samples as a frame.
+func (w *Writer) flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	samples := w.samples()
+	frame := encodeFrame(samples, uint(w.info.BitsPerSample), int(w.info.SampleRate), w.frameNum)
+	w.pending = w.pending[:0]
+	w.frameNum++
+
+	size := uint32(len(frame))
+	if w.info.MinFrameSize == 0 || size < w.info.MinFrameSize {
+		w.info.MinFrameSize = size
+	}
+	if size > w.info.MaxFrameSize {
+		w.info.MaxFrameSize = size
+	}
+	_, err := w.w.Write(frame)
+	return err
+}
+
+// samples splits the pending bytes into signed samples for each channel,
+// and adds them to the checksum.
+func (w *Writer) samples() [][]int64 {
+	channels := int(w.info.NbrChannels)
+	n := len(w.pending) / w.frameLen()
+	samples := make([][]int64, channels)
+	for c := range samples {
+		samples[c] = make([]int64, n)
+	}
+
+	p := w.pending
+	for i := 0; i < n; i++ {
+		for c := 0; c < channels; c++ {
+			samples[c][i] = readSample(p, w.depth)
+			p = p[w.depth:]
+		}
+	}
+
+	// The checksum covers signed samples, so 8-bit ones need converting.
+	if w.depth == 1 {
+		signed := make([]byte, len(w.pending))
+		for i, b := range w.pending {
+			signed[i] = b ^ 0x80
+		}
+		w.md5.Write(signed)
+	} else {
+		w.md5.Write(w.pending)
+	}
+	return samples
+}
+
+// readSample decodes a little-endian sample, as stored in a pcm.Buffer.
+func readSample(p []byte, depth int) int64 {
+	if depth == 1 {
+		return int64(p[0]) - 0x80 // unsigned
+	}
+	var v int64
+	for i := depth - 1; i >= 0; i-- {
+		v = v<<8 | int64(p[i])
+	}
+	// Sign-extend.
+	shift := 64 - 8*uint(depth)
+	return v << shift >> shift
+}
+
+// Close writes the last, possibly short, frame and, for seekable
+// destinations, patches the header. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if len(w.pending)%w.frameLen() != 0 {
+		return fmt.Errorf("%d bytes of a partial sample left over", len(w.pending)%w.frameLen())
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if w.size != UnknownSize && w.written != w.size {
+		return fmt.Errorf("wrote %d bytes, but promised %d", w.written, w.size)
+	}
+
+	w.info.TotalSamples = uint64(w.written) / uint64(w.frameLen())
+	copy(w.info.MD5[:], w.md5.Sum(nil))
+	if _, ok := w.w.(io.WriteSeeker); !ok {
+		return nil
+	}
+	return w.patch()
+}
+
+// patch rewrites the header with the final stream info, and then returns to
+// the end of the file.
+func (w *Writer) patch() error {
+	ws := w.w.(io.WriteSeeker)
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := ws.Seek(w.start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(w.info.encode()); err != nil {
+		return err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
@@ -0,0 +1,524 @@
+package wav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+// ADPCM formats store 4 bits per sample in fixed-size blocks, each starting
+// with a header which resynchronizes the decoder. They are encoded from, and
+// decoded to, 16-bit PCM. Sources: the IMA Digital Audio Focus and Technical
+// Working Groups' Recommended Practices (1992), and Microsoft's Multimedia
+// Standards Update (1994).
+
+// imaIndexTable adapts the IMA step size to each 4-bit code.
+var imaIndexTable = [16]int{
+	-1, -1, -1, -1, 2, 4, 6, 8,
+	-1, -1, -1, -1, 2, 4, 6, 8,
+}
+
+// imaStepTable lists the IMA step sizes.
+var imaStepTable = [89]int{
+	7, 8, 9, 10, 11, 12, 13, 14, 16, 17,
+	19, 21, 23, 25, 28, 31, 34, 37, 41, 45,
+	50, 55, 60, 66, 73, 80, 88, 97, 107, 118,
+	130, 143, 157, 173, 190, 209, 230, 253, 279, 307,
+	337, 371, 408, 449, 494, 544, 598, 658, 724, 796,
+	876, 963, 1060, 1166, 1282, 1411, 1552, 1707, 1878, 2066,
+	2272, 2499, 2749, 3024, 3327, 3660, 4026, 4428, 4871, 5358,
+	5894, 6484, 7132, 7845, 8630, 9493, 10442, 11487, 12635, 13899,
+	15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794, 32767,
+}
+
+// msAdaptTable adapts the MS ADPCM quantization step to each 4-bit code.
+var msAdaptTable = [16]int{
+	230, 230, 230, 230, 307, 409, 512, 614,
+	768, 614, 512, 409, 307, 230, 230, 230,
+}
+
+// msCoefs are the standard MS ADPCM predictor coefficient pairs, in units of
+// 1/256.
+var msCoefs = [][2]int16{
+	{256, 0},
+	{512, -256},
+	{0, 0},
+	{192, 64},
+	{240, 0},
+	{460, -208},
+	{392, -232},
+}
+
+// isADPCM reports whether the audio format is one of the ADPCM formats.
+func isADPCM(audioFormat uint16) bool {
+	return audioFormat == AudioFormatIMAADPCM || audioFormat == AudioFormatMSADPCM
+}
+
+// defaultBlockAlign returns the conventional ADPCM block size for the sample
+// rate: 256 bytes per channel up to 11025 Hz, doubling up to 22050 Hz, and
+// doubling again above that.
+func defaultBlockAlign(channels uint16, frequency uint32) uint16 {
+	perChannel := uint16(256)
+	switch {
+	case frequency > 22050:
+		perChannel = 1024
+	case frequency > 11025:
+		perChannel = 512
+	}
+	return perChannel * channels
+}
+
+// adpcmHeaderSize returns the size of the header which starts each block.
+func adpcmHeaderSize(audioFormat uint16, channels int) int {
+	if audioFormat == AudioFormatMSADPCM {
+		return 7 * channels
+	}
+	return 4 * channels
+}
+
+// samplesPerBlock returns how many samples per channel fit in an ADPCM block.
+// Both formats store the first sample of each channel in the header; MS ADPCM
+// stores a second one there too. IMA ADPCM codes samples in groups of 8 per
+// channel.
+func samplesPerBlock(audioFormat uint16, channels int, blockAlign int) int {
+	body := blockAlign - adpcmHeaderSize(audioFormat, channels)
+	if audioFormat == AudioFormatMSADPCM {
+		return body*2/channels + 2
+	}
+	return body/(4*channels)*8 + 1
+}
+
+// adpcmEncoder compresses 16-bit little-endian PCM into ADPCM blocks.
+type adpcmEncoder struct {
+	format          uint16
+	channels        int
+	blockAlign      int
+	samplesPerBlock int
+
+	// index is the IMA step index of each channel, carried across blocks.
+	index   []int
+	started bool
+	// pending holds the bytes of a block that is still being filled.
+	pending []byte
+}
+
+func newADPCMEncoder(w *WAV) *adpcmEncoder {
+	return &adpcmEncoder{
+		format:          w.AudioFormat,
+		channels:        int(w.NbrChannels),
+		blockAlign:      int(w.BytePerBloc),
+		samplesPerBlock: int(w.SamplesPerBlock),
+		index:           make([]int, w.NbrChannels),
+	}
+}
+
+// write takes 16-bit samples and returns any blocks which they complete.
+func (e *adpcmEncoder) write(p []byte) []byte {
+	var out []byte
+	full := e.samplesPerBlock * e.channels * 2
+	for len(p) > 0 {
+		n := full - len(e.pending)
+		if n > len(p) {
+			n = len(p)
+		}
+		e.pending = append(e.pending, p[:n]...)
+		p = p[n:]
+		if len(e.pending) == full {
+			out = append(out, e.encodeBlock(e.pending)...)
+			e.pending = e.pending[:0]
+		}
+	}
+	return out
+}
+
+// flush returns the last block, padded with silence, if any samples are
+// pending. Partial samples are dropped.
+func (e *adpcmEncoder) flush() []byte {
+	frame := e.channels * 2
+	pcm := e.pending[:len(e.pending)-len(e.pending)%frame]
+	e.pending = nil
+	if len(pcm) == 0 {
+		return nil
+	}
+	padded := make([]byte, e.samplesPerBlock*frame)
+	copy(padded, pcm)
+	return e.encodeBlock(padded)
+}
+
+// encodeBlock compresses a full block of samples.
+func (e *adpcmEncoder) encodeBlock(p []byte) []byte {
+	x := make([][]int, e.channels)
+	for c := range x {
+		x[c] = make([]int, e.samplesPerBlock)
+	}
+	for i := 0; i < e.samplesPerBlock; i++ {
+		for c := range x {
+			x[c][i] = int(int16(binary.LittleEndian.Uint16(p[2*(i*e.channels+c):])))
+		}
+	}
+	if e.format == AudioFormatMSADPCM {
+		return e.encodeMSBlock(x)
+	}
+	return e.encodeIMABlock(x)
+}
+
+// encodeIMABlock compresses a block of IMA ADPCM.
+func (e *adpcmEncoder) encodeIMABlock(x [][]int) []byte {
+	// Rather than adapting from the smallest step, start from one which
+	// suits the opening of the signal.
+	if !e.started {
+		e.started = true
+		for c := range x {
+			diff := abs(x[c][1] - x[c][0])
+			for e.index[c] < len(imaStepTable)-1 && imaStepTable[e.index[c]] < diff {
+				e.index[c]++
+			}
+		}
+	}
+
+	out := make([]byte, 0, e.blockAlign)
+	predictor := make([]int, e.channels)
+	for c := range x {
+		predictor[c] = x[c][0]
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16(x[c][0])))
+		out = append(out, byte(e.index[c]), 0)
+	}
+
+	// Each channel takes turns storing 8 samples in 4 bytes, low nibble
+	// first.
+	for i := 1; i < e.samplesPerBlock; i += 8 {
+		for c := range x {
+			var group [4]byte
+			for j := 0; j < 8; j++ {
+				code := imaEncode(x[c][i+j], &predictor[c], &e.index[c])
+				group[j/2] |= code << (4 * uint(j%2))
+			}
+			out = append(out, group[:]...)
+		}
+	}
+	return out
+}
+
+// imaEncode returns the IMA ADPCM code for a sample, and updates the decoder
+// state that the code leads to.
+func imaEncode(sample int, predictor, index *int) byte {
+	step := imaStepTable[*index]
+	diff := sample - *predictor
+	var code byte
+	if diff < 0 {
+		code = 8
+		diff = -diff
+	}
+	// Quantize diff*4/step into three bits, as the decoder reconstructs it.
+	for bit := byte(4); bit > 0; bit >>= 1 {
+		if diff >= step {
+			code |= bit
+			diff -= step
+		}
+		step >>= 1
+	}
+	imaDecode(code, predictor, index)
+	return code
+}
+
+// imaDecode applies an IMA ADPCM code to the decoder state, returning the new
+// sample.
+func imaDecode(code byte, predictor, index *int) int {
+	step := imaStepTable[*index]
+	diff := step >> 3
+	if code&4 != 0 {
+		diff += step
+	}
+	if code&2 != 0 {
+		diff += step >> 1
+	}
+	if code&1 != 0 {
+		diff += step >> 2
+	}
+	if code&8 != 0 {
+		*predictor = clampInt16(*predictor - diff)
+	} else {
+		*predictor = clampInt16(*predictor + diff)
+	}
+	*index += imaIndexTable[code]
+	if *index < 0 {
+		*index = 0
+	} else if *index >= len(imaStepTable) {
+		*index = len(imaStepTable) - 1
+	}
+	return *predictor
+}
+
+// msState is the decoder state of one MS ADPCM channel.
+type msState struct {
+	coef1, coef2     int
+	delta            int
+	sample1, sample2 int // the last and second-to-last samples
+}
+
+// encode returns the MS ADPCM code for a sample, and updates the state.
+func (s *msState) encode(sample int) byte {
+	predicted := (s.sample1*s.coef1 + s.sample2*s.coef2) >> 8
+	diff := sample - predicted
+	// Round to the nearest multiple of delta.
+	var n int
+	if diff >= 0 {
+		n = (diff + s.delta/2) / s.delta
+	} else {
+		n = (diff - s.delta/2) / s.delta
+	}
+	if n > 7 {
+		n = 7
+	} else if n < -8 {
+		n = -8
+	}
+	code := byte(n) & 0xF
+	s.decode(code)
+	return code
+}
+
+// decode applies an MS ADPCM code to the state, returning the new sample.
+func (s *msState) decode(code byte) int {
+	n := int(code)
+	if n >= 8 {
+		n -= 16
+	}
+	predicted := (s.sample1*s.coef1 + s.sample2*s.coef2) >> 8
+	sample := clampInt16(predicted + n*s.delta)
+	s.sample2, s.sample1 = s.sample1, sample
+	s.delta = msAdaptTable[code] * s.delta >> 8
+	if s.delta < 16 {
+		s.delta = 16
+	}
+	return sample
+}
+
+// encodeMSBlock compresses a block of MS ADPCM, picking for each channel the
+// predictor which reproduces it most closely.
+func (e *adpcmEncoder) encodeMSBlock(x [][]int) []byte {
+	states := make([]msState, e.channels)
+	predictors := make([]byte, e.channels)
+	for c := range x {
+		bestErr := math.Inf(1)
+		for p, coef := range msCoefs {
+			s := e.msStart(x[c], coef)
+			start := s
+			var sqErr float64
+			for _, v := range x[c][2:] {
+				s.encode(v)
+				d := float64(v - s.sample1)
+				sqErr += d * d
+			}
+			if sqErr < bestErr {
+				bestErr = sqErr
+				predictors[c] = byte(p)
+				states[c] = start
+			}
+		}
+	}
+
+	out := make([]byte, 0, e.blockAlign)
+	out = append(out, predictors...)
+	for _, s := range states {
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16(s.delta)))
+	}
+	for _, s := range states {
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16(s.sample1)))
+	}
+	for _, s := range states {
+		out = binary.LittleEndian.AppendUint16(out, uint16(int16(s.sample2)))
+	}
+
+	// Codes are interleaved across channels, high nibble first.
+	var (
+		b    byte
+		high = true
+	)
+	for i := 2; i < e.samplesPerBlock; i++ {
+		for c := range x {
+			code := states[c].encode(x[c][i])
+			if high {
+				b = code << 4
+			} else {
+				out = append(out, b|code)
+			}
+			high = !high
+		}
+	}
+	if !high {
+		out = append(out, b)
+	}
+	return out
+}
+
+// msStart returns the state at the start of an MS ADPCM block for the given
+// predictor, with an initial step size suited to the first prediction error.
+func (e *adpcmEncoder) msStart(x []int, coef [2]int16) msState {
+	s := msState{
+		coef1:   int(coef[0]),
+		coef2:   int(coef[1]),
+		sample1: x[1],
+		sample2: x[0],
+	}
+	predicted := (s.sample1*s.coef1 + s.sample2*s.coef2) >> 8
+	s.delta = abs(x[2]-predicted) / 4
+	if s.delta < 16 {
+		s.delta = 16
+	} else if s.delta > math.MaxInt16 {
+		s.delta = math.MaxInt16
+	}
+	return s
+}
+
+// decodeADPCM reads size bytes of ADPCM blocks from r, one block at a time,
+// and appends them to buf expanded to 16-bit little-endian PCM. A short final
+// block is decoded as far as it goes. If the fact chunk gives the number of
+// samples, the padding in the final block is dropped.
+func (w *WAV) decodeADPCM(buf *pcm.Buffer, r io.Reader, size uint64) error {
+	channels := int(w.NbrChannels)
+	blockAlign := uint64(w.BytePerBloc)
+	limit := uint64(math.MaxUint64)
+	if w.hasFact() {
+		samples := uint64(w.SampleLength)
+		if w.isRF64() && w.SampleLength == math.MaxUint32 {
+			samples = w.SampleCount64
+		}
+		if samples <= limit/(2*uint64(channels)) {
+			limit = samples * uint64(channels) * 2
+		}
+	}
+
+	data := make([]byte, blockAlign)
+	var written uint64
+	for size > 0 {
+		n := min(blockAlign, size)
+		if _, err := io.ReadFull(r, data[:n]); err != nil {
+			return err
+		}
+		size -= n
+
+		var (
+			block []byte
+			err   error
+		)
+		if w.format() == AudioFormatMSADPCM {
+			block, err = w.decodeMSBlock(data[:n], channels)
+		} else {
+			block, err = decodeIMABlock(data[:n], channels)
+		}
+		if err != nil {
+			return err
+		}
+		if room := limit - written; uint64(len(block)) > room {
+			block = block[:room]
+		}
+		_, _ = buf.Write(block) // always returns nil error
+		written += uint64(len(block))
+	}
+	return nil
+}
+
+// decodeIMABlock expands one block of IMA ADPCM.
+func decodeIMABlock(p []byte, channels int) ([]byte, error) {
+	if len(p) < 4*channels {
+		return nil, fmt.Errorf("IMA ADPCM block too short: %d bytes", len(p))
+	}
+	predictor := make([]int, channels)
+	index := make([]int, channels)
+	for c := range predictor {
+		predictor[c] = int(int16(binary.LittleEndian.Uint16(p[4*c:])))
+		index[c] = int(p[4*c+2])
+		if index[c] >= len(imaStepTable) {
+			return nil, fmt.Errorf("invalid IMA ADPCM step index: %d", index[c])
+		}
+	}
+	body := p[4*channels:]
+	groups := len(body) / (4 * channels)
+	n := 1 + 8*groups
+
+	samples := make([]int16, n*channels)
+	for c := range predictor {
+		samples[c] = int16(predictor[c])
+	}
+	for g := 0; g < groups; g++ {
+		for c := 0; c < channels; c++ {
+			group := body[4*(g*channels+c):]
+			for j := 0; j < 8; j++ {
+				code := group[j/2] >> (4 * uint(j%2)) & 0xF
+				i := 1 + 8*g + j
+				samples[i*channels+c] = int16(imaDecode(code, &predictor[c], &index[c]))
+			}
+		}
+	}
+	return int16Bytes(samples), nil
+}
+
+// decodeMSBlock expands one block of MS ADPCM, using the coefficients from
+// the fmt chunk.
+func (w *WAV) decodeMSBlock(p []byte, channels int) ([]byte, error) {
+	if len(p) < 7*channels {
+		return nil, fmt.Errorf("MS ADPCM block too short: %d bytes", len(p))
+	}
+	le := binary.LittleEndian
+	states := make([]msState, channels)
+	for c := range states {
+		i := int(p[c])
+		if i >= len(w.Coefs) {
+			return nil, fmt.Errorf("invalid MS ADPCM predictor: %d", i)
+		}
+		states[c] = msState{
+			coef1:   int(w.Coefs[i][0]),
+			coef2:   int(w.Coefs[i][1]),
+			delta:   int(int16(le.Uint16(p[channels+2*c:]))),
+			sample1: int(int16(le.Uint16(p[3*channels+2*c:]))),
+			sample2: int(int16(le.Uint16(p[5*channels+2*c:]))),
+		}
+	}
+	body := p[7*channels:]
+
+	samples := make([]int16, 0, (2+2*len(body)/channels)*channels)
+	for _, s := range states {
+		samples = append(samples, int16(s.sample2))
+	}
+	for _, s := range states {
+		samples = append(samples, int16(s.sample1))
+	}
+	c := 0
+	for _, b := range body {
+		for _, code := range [2]byte{b >> 4, b & 0xF} {
+			samples = append(samples, int16(states[c].decode(code)))
+			c = (c + 1) % channels
+		}
+	}
+	// Drop a trailing partial sample.
+	samples = samples[:len(samples)-len(samples)%channels]
+	return int16Bytes(samples), nil
+}
+
+// int16Bytes encodes samples as 16-bit little-endian PCM.
+func int16Bytes(samples []int16) []byte {
+	out := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(out[2*i:], uint16(s))
+	}
+	return out
+}
+
+func clampInt16(v int) int {
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	} else if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return v
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
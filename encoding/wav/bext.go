@@ -0,0 +1,155 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+// Bext holds the fields of a Broadcast Wave Format bext chunk. Source: EBU
+// Tech 3285.
+type Bext struct {
+	Description         string // up to 256 characters
+	Originator          string // up to 32 characters
+	OriginatorReference string // up to 32 characters
+	// Origination is the date and time that the audio was created. Only the
+	// date and the time to the second are stored.
+	Origination time.Time
+	// TimeReference is the number of samples since midnight at which the
+	// first sample of the audio plays.
+	TimeReference uint64
+	// Version is the version of the bext chunk. If zero when encoding, 2 is
+	// written.
+	Version uint16
+	UMID    [64]byte
+
+	// Loudness fields are in hundredths of LUFS, LU or dBTP, as specified by
+	// EBU R 128. They are only meaningful from version 2.
+	LoudnessValue        int16
+	LoudnessRange        int16
+	MaxTruePeakLevel     int16
+	MaxMomentaryLoudness int16
+	MaxShortTermLoudness int16
+
+	CodingHistory string
+}
+
+// bextChunk is the fixed part of a bext chunk body.
+type bextChunk struct {
+	Description          [256]byte
+	Originator           [32]byte
+	OriginatorReference  [32]byte
+	OriginationDate      [10]byte // yyyy-mm-dd
+	OriginationTime      [8]byte  // hh:mm:ss
+	TimeReference        uint64
+	Version              uint16
+	UMID                 [64]byte
+	LoudnessValue        int16
+	LoudnessRange        int16
+	MaxTruePeakLevel     int16
+	MaxMomentaryLoudness int16
+	MaxShortTermLoudness int16
+	Reserved             [180]byte
+}
+
+// bextSize is the size of the fixed part of a bext chunk body.
+const bextSize = 602
+
+const (
+	bextDateLayout = "2006-01-02"
+	bextTimeLayout = "15:04:05"
+)
+
+// SetStart sets the TimeReference so that the first sample of the audio
+// plays at the given time since midnight.
+func (b *Bext) SetStart(enc *pcm.Encoder, sinceMidnight time.Duration) {
+	b.TimeReference = uint64(sinceMidnight) * uint64(enc.Rate) / uint64(time.Second)
+}
+
+// SampleTime returns the time since midnight at which sample number i of the
+// audio plays.
+func (b *Bext) SampleTime(enc *pcm.Encoder, i int) time.Duration {
+	samples := b.TimeReference + uint64(i)
+	return time.Duration(samples * uint64(time.Second) / uint64(enc.Rate))
+}
+
+// encode serializes the body of the bext chunk.
+func (b *Bext) encode() []byte {
+	c := bextChunk{
+		TimeReference:        b.TimeReference,
+		Version:              b.Version,
+		UMID:                 b.UMID,
+		LoudnessValue:        b.LoudnessValue,
+		LoudnessRange:        b.LoudnessRange,
+		MaxTruePeakLevel:     b.MaxTruePeakLevel,
+		MaxMomentaryLoudness: b.MaxMomentaryLoudness,
+		MaxShortTermLoudness: b.MaxShortTermLoudness,
+	}
+	if c.Version == 0 {
+		c.Version = 2
+	}
+	copy(c.Description[:], b.Description)
+	copy(c.Originator[:], b.Originator)
+	copy(c.OriginatorReference[:], b.OriginatorReference)
+	if !b.Origination.IsZero() {
+		copy(c.OriginationDate[:], b.Origination.Format(bextDateLayout))
+		copy(c.OriginationTime[:], b.Origination.Format(bextTimeLayout))
+	}
+
+	var out bytes.Buffer
+	_ = binary.Write(&out, binary.LittleEndian, c)
+	out.WriteString(b.CodingHistory)
+	return out.Bytes()
+}
+
+// readBext decodes the body of a bext chunk.
+func readBext(data []byte) (*Bext, error) {
+	if len(data) < bextSize {
+		return nil, fmt.Errorf("bext chunk too short: %d bytes", len(data))
+	}
+	var c bextChunk
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &c); err != nil {
+		return nil, fmt.Errorf("bext chunk: %w", err)
+	}
+
+	b := &Bext{
+		Description:          cstring(c.Description[:]),
+		Originator:           cstring(c.Originator[:]),
+		OriginatorReference:  cstring(c.OriginatorReference[:]),
+		TimeReference:        c.TimeReference,
+		Version:              c.Version,
+		UMID:                 c.UMID,
+		LoudnessValue:        c.LoudnessValue,
+		LoudnessRange:        c.LoudnessRange,
+		MaxTruePeakLevel:     c.MaxTruePeakLevel,
+		MaxMomentaryLoudness: c.MaxMomentaryLoudness,
+		MaxShortTermLoudness: c.MaxShortTermLoudness,
+		CodingHistory:        cstring(data[bextSize:]),
+	}
+
+	// Some writers use other separators than the standard ones, so only
+	// compare the digits.
+	stamp := cstring(c.OriginationDate[:]) + " " + cstring(c.OriginationTime[:])
+	stamp = strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return ' '
+	}, stamp)
+	if t, err := time.Parse("2006 01 02 15 04 05", stamp); err == nil {
+		b.Origination = t
+	}
+	return b, nil
+}
+
+// cstring returns the text of a NUL-padded field.
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
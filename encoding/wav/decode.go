@@ -0,0 +1,403 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+var (
+	errNotRIFF    = errors.New("not a RIFF file")
+	errNotWAVE    = errors.New("not a WAVE file")
+	errNoFormat   = errors.New("missing fmt chunk")
+	errNoData     = errors.New("missing data chunk")
+	errDataBefore = errors.New("data chunk precedes fmt chunk")
+	errNoDS64     = errors.New("RF64 data chunk size needs a preceding ds64 chunk")
+)
+
+// Decoder reads WAV files.
+type Decoder struct {
+	r io.Reader
+
+	// WAV holds the header fields of the last decoded file.
+	WAV WAV
+
+	// Metadata holds the other chunks of the last decoded file.
+	Metadata Metadata
+}
+
+// NewDecoder creates a Decoder which reads a WAV file from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads a WAV file from r, returning its samples and a matching
+// Encoder.
+func Decode(r io.Reader) (*pcm.Buffer, *pcm.Encoder, error) {
+	return NewDecoder(r).Decode()
+}
+
+// Decode reads the WAV file, returning its samples and a matching Encoder.
+// Metadata chunks are collected into d.Metadata, and padding chunks are
+// skipped.
+func (d *Decoder) Decode() (*pcm.Buffer, *pcm.Encoder, error) {
+	var riff riffHeader
+	if err := binary.Read(d.r, binary.LittleEndian, &riff); err != nil {
+		return nil, nil, err
+	}
+	d.Metadata = Metadata{}
+	w := &d.WAV
+	*w = WAV{
+		FileTypeBlocID: riff.FileTypeBlocID,
+		FileSize:       riff.FileSize,
+		FileFormatID:   riff.FileFormatID,
+	}
+	if string(w.FileTypeBlocID[:]) != "RIFF" && !w.isRF64() {
+		return nil, nil, errNotRIFF
+	}
+	if string(w.FileFormatID[:]) != "WAVE" {
+		return nil, nil, errNotWAVE
+	}
+
+	var (
+		enc     *pcm.Encoder
+		buf     *pcm.Buffer
+		hasDS64 bool
+		hasData bool
+	)
+	for {
+		var ch chunkHeader
+		err := binary.Read(d.r, binary.LittleEndian, &ch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch string(ch.ID[:]) {
+		case "fmt ":
+			if err := d.readFormat(ch); err != nil {
+				return nil, nil, err
+			}
+			if err := w.validate(); err != nil {
+				return nil, nil, err
+			}
+			enc = w.pcmEncoder()
+
+		case "ds64":
+			if err := d.readDS64(ch); err != nil {
+				return nil, nil, err
+			}
+			hasDS64 = true
+
+		case "fact":
+			if err := d.readFact(ch); err != nil {
+				return nil, nil, err
+			}
+
+		case "data":
+			if enc == nil {
+				return nil, nil, errDataBefore
+			}
+			w.DataBlocID = ch.ID
+			w.DataSize = ch.Size
+			size := uint64(ch.Size)
+			if w.isRF64() && ch.Size == math.MaxUint32 {
+				if !hasDS64 {
+					return nil, nil, errNoDS64
+				}
+				size = w.DataSize64
+			}
+			if buf, err = d.readData(enc, size); err != nil {
+				return nil, nil, err
+			}
+			hasData = true
+
+		case "JUNK", "junk", "PAD ", "FLLR":
+			if err := d.skip(ch.Size); err != nil {
+				return nil, nil, err
+			}
+
+		default:
+			// Read the body as it arrives, rather than trusting the size in
+			// the header with a large allocation.
+			body, err := io.ReadAll(io.LimitReader(d.r, int64(ch.Size)))
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(body) != int(ch.Size) {
+				return nil, nil, io.ErrUnexpectedEOF
+			}
+			if err := d.skipPad(uint64(ch.Size)); err != nil {
+				return nil, nil, err
+			}
+			if err := d.Metadata.readChunk(ch.ID, body); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if enc == nil {
+		return nil, nil, errNoFormat
+	}
+	if !hasData {
+		return nil, nil, errNoData
+	}
+	return buf, enc, nil
+}
+
+// readData reads the body of a data chunk of the given size, expanding ADPCM
+// to 16-bit PCM.
+func (d *Decoder) readData(enc *pcm.Encoder, size uint64) (*pcm.Buffer, error) {
+	w := &d.WAV
+	adpcm := isADPCM(w.format())
+	// ADPCM files may end with a short block.
+	if !adpcm && size%uint64(w.BytePerBloc) != 0 {
+		return nil, fmt.Errorf(
+			"data size %d is not a multiple of block size %d",
+			size, w.BytePerBloc,
+		)
+	}
+	buf, err := enc.NewBuffer(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if adpcm {
+		if err := w.decodeADPCM(buf, d.r, size); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.CopyN(buf, d.r, int64(size)); err != nil {
+		return nil, err
+	}
+	return buf, d.skipPad(size)
+}
+
+// readFormat reads the body of a fmt chunk into the WAV header fields.
+func (d *Decoder) readFormat(ch chunkHeader) error {
+	if ch.Size < 16 {
+		return fmt.Errorf("fmt chunk too short: %d bytes", ch.Size)
+	}
+	var f formatChunk
+	if err := binary.Read(d.r, binary.LittleEndian, &f); err != nil {
+		return err
+	}
+	w := &d.WAV
+	w.FormatBlocID = ch.ID
+	w.BlocSize = ch.Size
+	w.AudioFormat = f.AudioFormat
+	w.NbrChannels = f.NbrChannels
+	w.Frequency = f.Frequency
+	w.BytePerSec = f.BytePerSec
+	w.BytePerBloc = f.BytePerBloc
+	w.BitsPerSample = f.BitsPerSample
+
+	rest := ch.Size - 16
+	if rest >= 2 {
+		if err := binary.Read(d.r, binary.LittleEndian, &w.CbSize); err != nil {
+			return err
+		}
+		rest -= 2
+	}
+	switch {
+	case w.AudioFormat == AudioFormatExtensible && w.CbSize >= 22 && rest >= 22:
+		ext := struct {
+			ValidBitsPerSample uint16
+			ChannelMask        uint32
+			SubFormat          [16]byte
+		}{}
+		if err := binary.Read(d.r, binary.LittleEndian, &ext); err != nil {
+			return err
+		}
+		w.ValidBitsPerSample = ext.ValidBitsPerSample
+		w.ChannelMask = ext.ChannelMask
+		w.SubFormat = ext.SubFormat
+		rest -= 22
+
+	case isADPCM(w.AudioFormat) && w.CbSize >= 2 && rest >= 2:
+		if err := binary.Read(d.r, binary.LittleEndian, &w.SamplesPerBlock); err != nil {
+			return err
+		}
+		rest -= 2
+		if w.AudioFormat != AudioFormatMSADPCM || rest < 2 {
+			break
+		}
+		var n uint16
+		if err := binary.Read(d.r, binary.LittleEndian, &n); err != nil {
+			return err
+		}
+		rest -= 2
+		if uint32(n)*4 > rest {
+			return fmt.Errorf("%d MS ADPCM coefficients overrun the fmt chunk", n)
+		}
+		w.Coefs = make([][2]int16, n)
+		if err := binary.Read(d.r, binary.LittleEndian, w.Coefs); err != nil {
+			return err
+		}
+		rest -= 4 * uint32(n)
+	}
+
+	// Skip any format extension we don't use.
+	return d.skip(rest)
+}
+
+// readFact reads the body of a fact chunk into the WAV header fields.
+func (d *Decoder) readFact(ch chunkHeader) error {
+	if ch.Size < 4 {
+		return fmt.Errorf("fact chunk too short: %d bytes", ch.Size)
+	}
+	w := &d.WAV
+	w.FactBlocID = ch.ID
+	w.FactSize = ch.Size
+	if err := binary.Read(d.r, binary.LittleEndian, &w.SampleLength); err != nil {
+		return err
+	}
+	return d.skip(ch.Size - 4)
+}
+
+// readDS64 reads the body of a ds64 chunk into the WAV header fields.
+func (d *Decoder) readDS64(ch chunkHeader) error {
+	if ch.Size < ds64Size {
+		return fmt.Errorf("ds64 chunk too short: %d bytes", ch.Size)
+	}
+	var ds ds64Chunk
+	if err := binary.Read(d.r, binary.LittleEndian, &ds); err != nil {
+		return err
+	}
+	if ds.DataSize > math.MaxInt64 {
+		return fmt.Errorf("data size %d is too large", ds.DataSize)
+	}
+	w := &d.WAV
+	w.DS64BlocID = ch.ID
+	w.DS64Size = ch.Size
+	w.RIFFSize64 = ds.RIFFSize
+	w.DataSize64 = ds.DataSize
+	w.SampleCount64 = ds.SampleCount
+
+	// Skip the table of other chunk sizes, which we don't need.
+	return d.skip(ch.Size - ds64Size)
+}
+
+// skip discards a chunk body of the given size, including its pad byte.
+func (d *Decoder) skip(size uint32) error {
+	if _, err := io.CopyN(io.Discard, d.r, int64(size)); err != nil {
+		return err
+	}
+	return d.skipPad(uint64(size))
+}
+
+// skipPad discards the pad byte which follows chunks of odd size. A missing
+// pad byte at the end of the file is tolerated.
+func (d *Decoder) skipPad(size uint64) error {
+	if size%2 == 0 {
+		return nil
+	}
+	var pad [1]byte
+	if _, err := io.ReadFull(d.r, pad[:]); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// validate checks that the format fields are self-consistent and describe
+// audio that pcm can represent.
+func (w *WAV) validate() error {
+	if w.NbrChannels == 0 {
+		return errors.New("no channels")
+	}
+	if w.Frequency == 0 {
+		return errors.New("zero sample rate")
+	}
+	if w.AudioFormat == AudioFormatExtensible {
+		if w.CbSize < 22 {
+			return fmt.Errorf("extensible format extension too short: %d bytes", w.CbSize)
+		}
+		if !bytes.Equal(w.SubFormat[2:], subFormatSuffix[:]) {
+			return fmt.Errorf("unsupported sub-format GUID: %x", w.SubFormat)
+		}
+		if w.ValidBitsPerSample > w.BitsPerSample {
+			return fmt.Errorf(
+				"%d valid bits exceed %d bits per sample",
+				w.ValidBitsPerSample, w.BitsPerSample,
+			)
+		}
+	}
+	switch w.format() {
+	case AudioFormatPCM:
+		switch w.BitsPerSample {
+		case 8, 16, 24, 32:
+		default:
+			return fmt.Errorf("unsupported bits per sample: %d", w.BitsPerSample)
+		}
+	case AudioFormatFloat:
+		switch w.BitsPerSample {
+		case 32, 64:
+		default:
+			return fmt.Errorf("unsupported bits per float sample: %d", w.BitsPerSample)
+		}
+	case AudioFormatALaw, AudioFormatMuLaw:
+		if w.BitsPerSample != 8 {
+			return fmt.Errorf("unsupported bits per G.711 sample: %d", w.BitsPerSample)
+		}
+	case AudioFormatIMAADPCM, AudioFormatMSADPCM:
+		return w.validateADPCM()
+	default:
+		return fmt.Errorf("unsupported audio format %d", w.format())
+	}
+	if want := w.NbrChannels * w.BitsPerSample / 8; w.BytePerBloc != want {
+		return fmt.Errorf("block size %d, expected %d", w.BytePerBloc, want)
+	}
+	if want := w.Frequency * uint32(w.BytePerBloc); w.BytePerSec != want {
+		return fmt.Errorf("byte rate %d, expected %d", w.BytePerSec, want)
+	}
+	return nil
+}
+
+// pcmEncoder returns the pcm.Encoder matching a validated format.
+func (w *WAV) pcmEncoder() *pcm.Encoder {
+	rate := int(w.Frequency)
+	depth := int(w.BitsPerSample / 8)
+	channels := int(w.NbrChannels)
+	if isADPCM(w.format()) {
+		depth = 2 // expanded to 16-bit PCM
+	}
+	var enc *pcm.Encoder
+	switch w.format() {
+	case AudioFormatFloat:
+		enc = pcm.NewFloat(rate, depth, channels)
+	case AudioFormatALaw:
+		enc = pcm.NewALaw(rate, channels)
+	case AudioFormatMuLaw:
+		enc = pcm.NewMuLaw(rate, channels)
+	default:
+		enc = pcm.New(rate, depth, channels)
+	}
+
+	// Ignore masks which don't describe every channel.
+	layout := pcm.ChannelLayout(w.ChannelMask)
+	if layout.Channels() == channels {
+		enc.Layout = layout
+	}
+	return enc
+}
+
+// validateADPCM checks the format fields of an ADPCM header. The byte rate is
+// informational, so it isn't checked.
+func (w *WAV) validateADPCM() error {
+	if w.BitsPerSample != 4 {
+		return fmt.Errorf("unsupported bits per ADPCM sample: %d", w.BitsPerSample)
+	}
+	if int(w.BytePerBloc) <= adpcmHeaderSize(w.format(), int(w.NbrChannels)) {
+		return fmt.Errorf("ADPCM block size %d is too small", w.BytePerBloc)
+	}
+	if w.format() == AudioFormatMSADPCM && len(w.Coefs) == 0 {
+		w.Coefs = msCoefs
+	}
+	return nil
+}
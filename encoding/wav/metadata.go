@@ -0,0 +1,362 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Common LIST/INFO tag IDs.
+const (
+	InfoTitle     = "INAM"
+	InfoArtist    = "IART"
+	InfoComment   = "ICMT"
+	InfoCopyright = "ICOP"
+	InfoDate      = "ICRD"
+	InfoGenre     = "IGNR"
+	InfoSoftware  = "ISFT"
+)
+
+// Loop types for SampleLoop.Type.
+const (
+	LoopForward  = 0
+	LoopPingPong = 1
+	LoopBackward = 2
+)
+
+// Metadata holds the chunks of a WAV file other than those describing the
+// format and containing the samples.
+type Metadata struct {
+	// Bext holds the Broadcast Wave Format description, if any.
+	Bext *Bext
+
+	// Info holds the LIST/INFO tags, keyed by four-character IDs such as
+	// InfoTitle. Encoding fails on keys of any other length.
+	Info map[string]string
+
+	// Cues are markers in the audio, from the cue chunk. Their labels come
+	// from the labl chunks in LIST/adtl.
+	Cues []Cue
+
+	// Sampler holds the smpl chunk, with the loop regions used by samplers.
+	Sampler *Sampler
+
+	// Chunks holds any other chunks, which are written back untouched.
+	Chunks []Chunk
+
+	// adtl holds LIST/adtl subchunks other than labl, for writing back.
+	adtl []Chunk
+}
+
+// Cue is a marker at a sample in the audio.
+type Cue struct {
+	// ID identifies the cue, and is referenced by SampleLoop.CuePointID. If
+	// zero when encoding, it is set to the cue's index plus one. Encoding
+	// fails if two cues end up with the same ID.
+	ID uint32
+	// Offset is the number of samples from the start of the audio.
+	Offset uint32
+	Label  string
+}
+
+// Sampler holds the fields of a smpl chunk.
+type Sampler struct {
+	Manufacturer uint32
+	Product      uint32
+	// SamplePeriod is the duration of a sample in nanoseconds. If zero when
+	// encoding, it is derived from the sample rate.
+	SamplePeriod      uint32
+	MIDIUnityNote     uint32
+	MIDIPitchFraction uint32
+	SMPTEFormat       uint32
+	SMPTEOffset       uint32
+	Loops             []SampleLoop
+	SamplerData       []byte
+}
+
+// SampleLoop is a loop region in a smpl chunk. Start and End are sample
+// offsets, and End is inclusive.
+type SampleLoop struct {
+	CuePointID uint32
+	Type       uint32
+	Start      uint32
+	End        uint32
+	Fraction   uint32
+	PlayCount  uint32 // 0 loops forever
+}
+
+// Chunk is a raw chunk.
+type Chunk struct {
+	ID   [4]byte
+	Data []byte
+}
+
+// smplHeader is the fixed part of a smpl chunk body.
+type smplHeader struct {
+	Manufacturer      uint32
+	Product           uint32
+	SamplePeriod      uint32
+	MIDIUnityNote     uint32
+	MIDIPitchFraction uint32
+	SMPTEFormat       uint32
+	SMPTEOffset       uint32
+	NumSampleLoops    uint32
+	SamplerDataSize   uint32
+}
+
+// cuePoint is an entry of a cue chunk body.
+type cuePoint struct {
+	ID           uint32
+	Position     uint32
+	DataChunkID  [4]byte
+	ChunkStart   uint32
+	BlockStart   uint32
+	SampleOffset uint32
+}
+
+// encode serializes the metadata as a series of chunks. The frequency is used
+// to fill in a missing Sampler.SamplePeriod.
+func (m *Metadata) encode(frequency uint32) ([]byte, error) {
+	if m == nil {
+		return nil, nil
+	}
+	var out bytes.Buffer
+	le := binary.LittleEndian
+
+	if m.Bext != nil {
+		writeChunk(&out, fourCC("bext"), m.Bext.encode())
+	}
+
+	if len(m.Info) != 0 {
+		ids := make([]string, 0, len(m.Info))
+		for id := range m.Info {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		var list bytes.Buffer
+		list.WriteString("INFO")
+		for _, id := range ids {
+			if len(id) != 4 {
+				return nil, fmt.Errorf("INFO key %q is not four bytes", id)
+			}
+			writeChunk(&list, fourCC(id), zstring(m.Info[id]))
+		}
+		writeChunk(&out, fourCC("LIST"), list.Bytes())
+	}
+
+	if len(m.Cues) != 0 {
+		var cue, adtl bytes.Buffer
+		_ = binary.Write(&cue, le, uint32(len(m.Cues)))
+		seen := make(map[uint32]bool, len(m.Cues))
+		for i, c := range m.Cues {
+			id := c.ID
+			if id == 0 {
+				id = uint32(i + 1)
+			}
+			if seen[id] {
+				return nil, fmt.Errorf("duplicate cue ID %d", id)
+			}
+			seen[id] = true
+			_ = binary.Write(&cue, le, cuePoint{
+				ID:           id,
+				Position:     c.Offset,
+				DataChunkID:  fourCC("data"),
+				SampleOffset: c.Offset,
+			})
+			if c.Label != "" {
+				labl := binary.LittleEndian.AppendUint32(nil, id)
+				writeChunk(&adtl, fourCC("labl"), append(labl, zstring(c.Label)...))
+			}
+		}
+		for _, ch := range m.adtl {
+			writeChunk(&adtl, ch.ID, ch.Data)
+		}
+		writeChunk(&out, fourCC("cue "), cue.Bytes())
+		if adtl.Len() != 0 {
+			writeChunk(&out, fourCC("LIST"), append([]byte("adtl"), adtl.Bytes()...))
+		}
+	}
+
+	if s := m.Sampler; s != nil {
+		period := s.SamplePeriod
+		if period == 0 && frequency != 0 {
+			period = 1_000_000_000 / frequency
+		}
+		var smpl bytes.Buffer
+		_ = binary.Write(&smpl, le, smplHeader{
+			Manufacturer:      s.Manufacturer,
+			Product:           s.Product,
+			SamplePeriod:      period,
+			MIDIUnityNote:     s.MIDIUnityNote,
+			MIDIPitchFraction: s.MIDIPitchFraction,
+			SMPTEFormat:       s.SMPTEFormat,
+			SMPTEOffset:       s.SMPTEOffset,
+			NumSampleLoops:    uint32(len(s.Loops)),
+			SamplerDataSize:   uint32(len(s.SamplerData)),
+		})
+		_ = binary.Write(&smpl, le, s.Loops)
+		smpl.Write(s.SamplerData)
+		writeChunk(&out, fourCC("smpl"), smpl.Bytes())
+	}
+
+	for _, ch := range m.Chunks {
+		writeChunk(&out, ch.ID, ch.Data)
+	}
+	return out.Bytes(), nil
+}
+
+// readChunk decodes a metadata chunk body. Chunks which aren't understood
+// are kept in Chunks.
+func (m *Metadata) readChunk(id [4]byte, data []byte) error {
+	switch string(id[:]) {
+	case "bext":
+		b, err := readBext(data)
+		if err != nil {
+			return err
+		}
+		m.Bext = b
+		return nil
+	case "LIST":
+		if len(data) >= 4 {
+			switch string(data[:4]) {
+			case "INFO":
+				return m.readInfo(data[4:])
+			case "adtl":
+				return m.readAdtl(data[4:])
+			}
+		}
+	case "cue ":
+		return m.readCue(data)
+	case "smpl":
+		return m.readSmpl(data)
+	}
+	m.Chunks = append(m.Chunks, Chunk{ID: id, Data: data})
+	return nil
+}
+
+func (m *Metadata) readInfo(data []byte) error {
+	return eachChunk(data, func(id [4]byte, body []byte) {
+		if m.Info == nil {
+			m.Info = make(map[string]string)
+		}
+		m.Info[string(id[:])] = strings.TrimRight(string(body), "\x00")
+	})
+}
+
+func (m *Metadata) readAdtl(data []byte) error {
+	return eachChunk(data, func(id [4]byte, body []byte) {
+		if string(id[:]) != "labl" || len(body) < 4 {
+			m.adtl = append(m.adtl, Chunk{ID: id, Data: body})
+			return
+		}
+		cueID := binary.LittleEndian.Uint32(body)
+		label := strings.TrimRight(string(body[4:]), "\x00")
+		for i := range m.Cues {
+			if m.Cues[i].ID == cueID {
+				m.Cues[i].Label = label
+				return
+			}
+		}
+		// The cue chunk usually comes first, but may not.
+		m.Cues = append(m.Cues, Cue{ID: cueID, Label: label})
+	})
+}
+
+func (m *Metadata) readCue(data []byte) error {
+	r := bytes.NewReader(data)
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return fmt.Errorf("cue chunk: %w", err)
+	}
+	if uint64(n)*24 > uint64(r.Len()) {
+		return fmt.Errorf("cue chunk too short for %d cue points", n)
+	}
+	points := make([]cuePoint, n)
+	if err := binary.Read(r, binary.LittleEndian, points); err != nil {
+		return fmt.Errorf("cue chunk: %w", err)
+	}
+	for _, p := range points {
+		found := false
+		for i := range m.Cues {
+			if m.Cues[i].ID == p.ID {
+				m.Cues[i].Offset = p.SampleOffset
+				found = true
+			}
+		}
+		if !found {
+			m.Cues = append(m.Cues, Cue{ID: p.ID, Offset: p.SampleOffset})
+		}
+	}
+	return nil
+}
+
+func (m *Metadata) readSmpl(data []byte) error {
+	r := bytes.NewReader(data)
+	var h smplHeader
+	if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+		return fmt.Errorf("smpl chunk: %w", err)
+	}
+	if uint64(h.NumSampleLoops)*24+uint64(h.SamplerDataSize) > uint64(r.Len()) {
+		return fmt.Errorf("smpl chunk too short for %d loops", h.NumSampleLoops)
+	}
+	s := &Sampler{
+		Manufacturer:      h.Manufacturer,
+		Product:           h.Product,
+		SamplePeriod:      h.SamplePeriod,
+		MIDIUnityNote:     h.MIDIUnityNote,
+		MIDIPitchFraction: h.MIDIPitchFraction,
+		SMPTEFormat:       h.SMPTEFormat,
+		SMPTEOffset:       h.SMPTEOffset,
+		Loops:             make([]SampleLoop, h.NumSampleLoops),
+		SamplerData:       make([]byte, h.SamplerDataSize),
+	}
+	if err := binary.Read(r, binary.LittleEndian, s.Loops); err != nil {
+		return fmt.Errorf("smpl chunk: %w", err)
+	}
+	_, _ = r.Read(s.SamplerData)
+	m.Sampler = s
+	return nil
+}
+
+// eachChunk calls f with each subchunk packed in data.
+func eachChunk(data []byte, f func(id [4]byte, body []byte)) error {
+	for len(data) >= 8 {
+		var id [4]byte
+		copy(id[:], data)
+		size := binary.LittleEndian.Uint32(data[4:])
+		data = data[8:]
+		if uint64(size) > uint64(len(data)) {
+			return fmt.Errorf("%q subchunk overruns its parent", id[:])
+		}
+		f(id, data[:size])
+		if size%2 != 0 && size < uint32(len(data)) {
+			size++
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// writeChunk writes a chunk with its header and pad byte.
+func writeChunk(out *bytes.Buffer, id [4]byte, data []byte) {
+	_ = binary.Write(out, binary.LittleEndian, chunkHeader{ID: id, Size: uint32(len(data))})
+	out.Write(data)
+	if len(data)%2 != 0 {
+		out.WriteByte(0)
+	}
+}
+
+// fourCC converts a chunk ID into its binary form.
+func fourCC(id string) [4]byte {
+	var b [4]byte
+	copy(b[:], id)
+	return b
+}
+
+// zstring returns s as a NUL-terminated string.
+func zstring(s string) []byte {
+	return append([]byte(s), 0)
+}
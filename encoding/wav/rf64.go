@@ -0,0 +1,55 @@
+package wav
+
+import (
+	"errors"
+	"math"
+)
+
+// Container selects the file layout: RIFF, which is limited to 4 GiB, or
+// RF64, which lifts that limit with 64-bit sizes in a ds64 chunk. Source:
+// EBU Tech 3306.
+type Container int
+
+const (
+	// ContainerAuto writes RIFF files, switching to RF64 when the data
+	// exceeds the RIFF limit. It is the zero value.
+	ContainerAuto Container = iota
+	// ContainerRIFF always writes RIFF files. Data exceeding the RIFF limit
+	// causes ErrTooLarge.
+	ContainerRIFF
+	// ContainerRF64 always writes RF64 files.
+	ContainerRF64
+)
+
+// ErrTooLarge is returned when data exceeds the RIFF size limit, and RF64 is
+// not allowed or there is no room left to switch to it.
+var ErrTooLarge = errors.New("data exceeds the 4 GiB RIFF limit")
+
+// maxRIFFSize is the largest FileSize that a RIFF header can hold. It is a
+// variable so that tests can switch to RF64 without writing 4 GiB.
+var maxRIFFSize uint64 = math.MaxUint32
+
+// ds64Size is the size of a ds64 chunk body without a table.
+const ds64Size = 28
+
+// ds64Chunk is the body of a ds64 chunk, holding the 64-bit sizes of an RF64
+// file.
+type ds64Chunk struct {
+	RIFFSize    uint64
+	DataSize    uint64
+	SampleCount uint64
+	TableLength uint32
+}
+
+// reserveDS64 adds a JUNK chunk which can later be replaced by a ds64 chunk,
+// if the data outgrows RIFF.
+func (w *WAV) reserveDS64() {
+	copy(w.DS64BlocID[:], "JUNK")
+	w.DS64Size = ds64Size
+}
+
+// isRF64 reports whether the header uses the RF64 layout.
+func (w *WAV) isRF64() bool {
+	id := string(w.FileTypeBlocID[:])
+	return id == "RF64" || id == "BW64"
+}
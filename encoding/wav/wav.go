@@ -1,51 +1,142 @@
-// Package wav allows writing PCM or float audio data to a WAV file.
+// Package wav allows reading and writing PCM, float, G.711 or ADPCM audio data
+// in WAV files.
 package wav
 
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
+
+	"github.com/chaimleib/synth/pcm"
 )
 
-// WAV is the file header layout. Source: https://en.wikipedia.org/wiki/WAV.
+// WAV holds the fields of the file header. Source:
+// https://en.wikipedia.org/wiki/WAV.
 type WAV struct {
 	// Master RIFF chunk
-	FileTypeBlocID [4]byte // value:"RIFF"
-	FileSize       uint32  // 4 + 8 + BlocSize + 4 + DataSize
+	FileTypeBlocID [4]byte // value:"RIFF" or "RF64"
+	FileSize       uint32  // 4 + [8 + DS64Size] + 8 + BlocSize + [8 + FactSize] + 8 + DataSize + pad byte
 	FileFormatID   [4]byte // value:"WAVE"
 
+	// ds64 chunk, present in RF64 files, where FileSize, SampleLength and
+	// DataSize are all 0xFFFFFFFF; or a JUNK chunk reserving space for it
+	DS64BlocID    [4]byte // value:"ds64" or "JUNK", or empty if absent
+	DS64Size      uint32  // 28
+	RIFFSize64    uint64  // replaces FileSize
+	DataSize64    uint64  // replaces DataSize
+	SampleCount64 uint64  // replaces SampleLength
+
 	// Format chunk
 	FormatBlocID  [4]byte // value:"fmt "
-	BlocSize      uint32  // chunk size - 8 bytes, which is 16 here
+	BlocSize      uint32  // chunk size - 8 bytes: 16 for PCM, else at least 18
 	AudioFormat   uint16
 	NbrChannels   uint16
 	Frequency     uint32
 	BytePerSec    uint32 // Frequency * BytePerBloc
 	BytePerBloc   uint16 // NbrChannels * BitsPerSample / 8
 	BitsPerSample uint16
-	DataBlocID    [4]byte // value:"data"
+	CbSize        uint16 // size of the format extension; absent for PCM
+
+	// Format extension, present if AudioFormat is AudioFormatExtensible
+	ValidBitsPerSample uint16
+	ChannelMask        uint32   // speaker positions; see pcm.ChannelLayout
+	SubFormat          [16]byte // GUID whose first two bytes are the audio format
+
+	// Format extension, present for ADPCM formats
+	SamplesPerBlock uint16     // per channel
+	Coefs           [][2]int16 // MS ADPCM predictor coefficients
+
+	// Fact chunk, required for formats other than PCM
+	FactBlocID   [4]byte // value:"fact", or empty if absent
+	FactSize     uint32  // 4
+	SampleLength uint32  // number of samples per channel
+
+	// metadata holds the encoded chunks from a Metadata, which precede the
+	// data chunk.
+	metadata []byte
 
 	// Data chunk
-	DataSize uint32
+	DataBlocID [4]byte // value:"data"
+	DataSize   uint32
 	// SampledData has to be appended manually, since encoding/binary only works
 	// with fixed-length fields.
 	// SampledData []byte
 }
 
 const (
-	AudioFormatPCM   = 1
-	AudioFormatFloat = 3
+	AudioFormatPCM        = 1
+	AudioFormatMSADPCM    = 2
+	AudioFormatFloat      = 3
+	AudioFormatALaw       = 6
+	AudioFormatMuLaw      = 7
+	AudioFormatIMAADPCM   = 0x11
+	AudioFormatExtensible = 0xFFFE
 )
 
+// subFormatSuffix follows the audio format in a SubFormat GUID.
+var subFormatSuffix = [14]byte{
+	0x00, 0x00, 0x00, 0x00, 0x10, 0x00,
+	0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71,
+}
+
+// subFormat returns the SubFormat GUID for an audio format.
+func subFormat(audioFormat uint16) [16]byte {
+	var guid [16]byte
+	binary.LittleEndian.PutUint16(guid[:], audioFormat)
+	copy(guid[2:], subFormatSuffix[:])
+	return guid
+}
+
+// riffHeader is the start of the master RIFF chunk.
+type riffHeader struct {
+	FileTypeBlocID [4]byte
+	FileSize       uint32
+	FileFormatID   [4]byte
+}
+
+// chunkHeader starts every chunk inside the master RIFF chunk.
+type chunkHeader struct {
+	ID   [4]byte
+	Size uint32
+}
+
+// formatChunk is the body of a basic 16-byte fmt chunk.
+type formatChunk struct {
+	AudioFormat   uint16
+	NbrChannels   uint16
+	Frequency     uint32
+	BytePerSec    uint32
+	BytePerBloc   uint16
+	BitsPerSample uint16
+}
+
 type encoder struct {
 	AudioFormat uint16
 	NbrChannels uint16
 	Frequency   uint32
 	ByteDepth   int
+
+	// Container selects between RIFF and RF64 files.
+	Container Container
+
+	// Metadata, if set, is written before the samples.
+	Metadata *Metadata
+
+	// ChannelMask tags which speakers the channels feed. If set, or if there
+	// are more than 2 channels or 16 bits, the WAVE_FORMAT_EXTENSIBLE header
+	// is used.
+	ChannelMask uint32
+
+	// BlockAlign is the size of each ADPCM block, including its header. If
+	// zero, a size suited to the sample rate is used.
+	BlockAlign uint16
 }
 
 // NewEncoder creates a new encoder, which describes the format of the audio
-// samples to be encoded.
+// samples to be encoded. For the ADPCM formats, the samples are compressed
+// from 16-bit PCM, so byteDepth must be 2.
 func NewEncoder(audioFormat, nbrChannels, byteDepth, frequency int) *encoder {
 	return &encoder{
 		AudioFormat: uint16(audioFormat),
@@ -55,12 +146,63 @@ func NewEncoder(audioFormat, nbrChannels, byteDepth, frequency int) *encoder {
 	}
 }
 
+// NewEncoderFor creates a new encoder for samples produced by a pcm.Encoder,
+// picking the audio format which matches its sample format.
+func NewEncoderFor(enc *pcm.Encoder) *encoder {
+	audioFormat := AudioFormatPCM
+	switch {
+	case enc.Format.IsFloat():
+		audioFormat = AudioFormatFloat
+	case enc.Format == pcm.FormatALaw:
+		audioFormat = AudioFormatALaw
+	case enc.Format == pcm.FormatMuLaw:
+		audioFormat = AudioFormatMuLaw
+	}
+	e := NewEncoder(audioFormat, enc.Channels, enc.Depth, enc.Rate)
+	if enc.Layout != 0 || enc.Channels > 2 {
+		e.ChannelMask = uint32(enc.ChannelLayout())
+	}
+	return e
+}
+
+// extensible reports whether the WAVE_FORMAT_EXTENSIBLE header is needed.
+func (e *encoder) extensible() bool {
+	if isADPCM(e.AudioFormat) {
+		return false // has its own format extension
+	}
+	return e.NbrChannels > 2 || e.ByteDepth > 2 || e.ChannelMask != 0
+}
+
 // Encode takes an io.Reader and returns a buffer containing a WAV file.
 func (e *encoder) Encode(r io.Reader) ([]byte, error) {
 	buf, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
+
+	var out bytes.Buffer
+	w, err := e.NewWriter(&out, int64(len(buf)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the audio samples.
+	if _, err = w.Write(buf); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// header returns the file header for the given number of bytes of samples,
+// or for UnknownSize, which is patched later.
+func (e *encoder) header(dataSize int64) (*WAV, error) {
+	if isADPCM(e.AudioFormat) && e.ByteDepth != 2 {
+		return nil, fmt.Errorf("ADPCM needs 16-bit samples, not a byte depth of %d", e.ByteDepth)
+	}
 	w := new(WAV)
 
 	// Set all the fixed string fields.
@@ -74,27 +216,231 @@ func (e *encoder) Encode(r io.Reader) ([]byte, error) {
 	w.AudioFormat = e.AudioFormat
 	w.NbrChannels = e.NbrChannels
 	w.Frequency = e.Frequency
+	if e.extensible() {
+		w.BlocSize = 40
+		w.AudioFormat = AudioFormatExtensible
+		w.CbSize = 22
+		w.ValidBitsPerSample = 8 * uint16(e.ByteDepth)
+		w.ChannelMask = e.ChannelMask
+		w.SubFormat = subFormat(e.AudioFormat)
+	} else if e.AudioFormat != AudioFormatPCM {
+		// Other formats need the extension size, even if it is zero.
+		w.BlocSize = 18
+	}
+	if w.AudioFormat != AudioFormatPCM {
+		copy(w.FactBlocID[:], "fact")
+		w.FactSize = 4
+	}
 
 	// Derived description fields.
 	w.BytePerBloc = e.NbrChannels * uint16(e.ByteDepth)
 	w.BytePerSec = e.Frequency * uint32(w.BytePerBloc)
 	w.BitsPerSample = 8 * uint16(e.ByteDepth)
-	w.DataSize = uint32(len(buf))
-	w.FileSize = 16 + w.BlocSize + w.DataSize
-
-	var out bytes.Buffer
-
-	// Write the fixed-length struct fields.
-	err = binary.Write(&out, binary.LittleEndian, w)
+	if isADPCM(e.AudioFormat) {
+		if err := w.setADPCM(e.BlockAlign); err != nil {
+			return nil, err
+		}
+	}
+	metadata, err := e.Metadata.encode(e.Frequency)
 	if err != nil {
 		return nil, err
 	}
+	w.metadata = metadata
 
-	// Write the audio samples.
-	_, err = out.Write(buf)
-	if err != nil {
+	// Leave room for a ds64 chunk if the data might outgrow RIFF.
+	switch e.Container {
+	case ContainerRF64:
+		w.reserveDS64()
+		copy(w.FileTypeBlocID[:], "RF64")
+	case ContainerAuto:
+		if dataSize == UnknownSize || !w.fits(uint64(dataSize)) {
+			w.reserveDS64()
+		}
+	}
+
+	if dataSize == UnknownSize {
+		dataSize = 0
+	}
+	size := uint64(dataSize)
+	if err := w.setDataSize(w.encodedSize(size), w.frames(size)); err != nil {
 		return nil, err
 	}
+	return w, nil
+}
 
-	return out.Bytes(), nil
+// setADPCM fills in the fields of an ADPCM header with the given block size,
+// or a default one if zero.
+func (w *WAV) setADPCM(blockAlign uint16) error {
+	if blockAlign == 0 {
+		blockAlign = defaultBlockAlign(w.NbrChannels, w.Frequency)
+	}
+	channels := int(w.NbrChannels)
+	spb := samplesPerBlock(w.AudioFormat, channels, int(blockAlign))
+	// An MS ADPCM block starts with two whole samples, and must encode at
+	// least one more.
+	minSPB := 2
+	if w.AudioFormat == AudioFormatMSADPCM {
+		minSPB = 3
+	}
+	if spb < minSPB || spb > math.MaxUint16 {
+		return fmt.Errorf("invalid ADPCM block size: %d", blockAlign)
+	}
+	if w.AudioFormat == AudioFormatIMAADPCM && (int(blockAlign)-4*channels)%(4*channels) != 0 {
+		return fmt.Errorf("IMA ADPCM block size %d doesn't hold whole sample groups", blockAlign)
+	}
+
+	w.BytePerBloc = blockAlign
+	w.SamplesPerBlock = uint16(spb)
+	w.BitsPerSample = 4
+	w.BytePerSec = uint32(uint64(w.Frequency) * uint64(blockAlign) / uint64(spb))
+	w.CbSize = 2
+	if w.AudioFormat == AudioFormatMSADPCM {
+		w.Coefs = msCoefs
+		w.CbSize += 2 + 4*uint16(len(w.Coefs))
+	}
+	w.BlocSize = 18 + uint32(w.CbSize)
+	return nil
+}
+
+// frames returns the number of samples per channel in the given number of
+// bytes of samples, as taken by a Writer.
+func (w *WAV) frames(size uint64) uint64 {
+	if isADPCM(w.format()) {
+		return size / (2 * uint64(w.NbrChannels))
+	}
+	return size / uint64(w.BytePerBloc)
+}
+
+// encodedSize returns the size of the data chunk holding the given number of
+// bytes of samples, as taken by a Writer. ADPCM pads the last block.
+func (w *WAV) encodedSize(size uint64) uint64 {
+	if !isADPCM(w.format()) {
+		return size
+	}
+	frames := size / (2 * uint64(w.NbrChannels))
+	spb := uint64(w.SamplesPerBlock)
+	return (frames + spb - 1) / spb * uint64(w.BytePerBloc)
+}
+
+// riffSize returns what the RIFF FileSize would be for the given number of
+// bytes of samples.
+func (w *WAV) riffSize(dataSize uint64) uint64 {
+	size := 4 + (8 + uint64(w.BlocSize)) + (8 + dataSize + dataSize%2)
+	if w.DS64BlocID != [4]byte{} {
+		size += 8 + uint64(w.DS64Size)
+	}
+	if w.hasFact() {
+		size += 8 + uint64(w.FactSize)
+	}
+	size += uint64(len(w.metadata))
+	return size
+}
+
+// fits reports whether the given number of bytes of samples fit in a RIFF
+// file.
+func (w *WAV) fits(dataSize uint64) bool {
+	return w.riffSize(dataSize) <= maxRIFFSize
+}
+
+// setDataSize updates DataSize, the number of samples per channel, and the
+// fields which depend on them. If the data doesn't fit in a RIFF file, it
+// switches to RF64 if a ds64 chunk or space for one is present, or else
+// returns ErrTooLarge.
+func (w *WAV) setDataSize(dataSize, sampleLength uint64) error {
+	riffSize := w.riffSize(dataSize)
+
+	if !w.isRF64() && riffSize <= maxRIFFSize {
+		w.FileSize = uint32(riffSize)
+		w.DataSize = uint32(dataSize)
+		if w.hasFact() {
+			w.SampleLength = uint32(sampleLength)
+		}
+		return nil
+	}
+
+	if w.DS64BlocID == [4]byte{} {
+		return ErrTooLarge
+	}
+	copy(w.FileTypeBlocID[:], "RF64")
+	copy(w.DS64BlocID[:], "ds64")
+	w.RIFFSize64 = riffSize
+	w.DataSize64 = dataSize
+	w.SampleCount64 = sampleLength
+	w.FileSize = math.MaxUint32
+	w.DataSize = math.MaxUint32
+	if w.hasFact() {
+		w.SampleLength = math.MaxUint32
+	}
+	return nil
+}
+
+// format returns the audio format, looking inside the SubFormat of
+// extensible headers.
+func (w *WAV) format() uint16 {
+	if w.AudioFormat == AudioFormatExtensible {
+		return binary.LittleEndian.Uint16(w.SubFormat[:])
+	}
+	return w.AudioFormat
+}
+
+// hasFact reports whether the header includes a fact chunk.
+func (w *WAV) hasFact() bool {
+	return w.FactBlocID != [4]byte{}
+}
+
+// encode serializes the chunks which precede the samples.
+func (w *WAV) encode() []byte {
+	var out bytes.Buffer
+	le := binary.LittleEndian
+
+	// Writes to a bytes.Buffer never fail.
+	_ = binary.Write(&out, le, riffHeader{
+		FileTypeBlocID: w.FileTypeBlocID,
+		FileSize:       w.FileSize,
+		FileFormatID:   w.FileFormatID,
+	})
+
+	if w.DS64BlocID != [4]byte{} {
+		_ = binary.Write(&out, le, chunkHeader{ID: w.DS64BlocID, Size: w.DS64Size})
+		_ = binary.Write(&out, le, ds64Chunk{
+			RIFFSize:    w.RIFFSize64,
+			DataSize:    w.DataSize64,
+			SampleCount: w.SampleCount64,
+		})
+	}
+
+	_ = binary.Write(&out, le, chunkHeader{ID: w.FormatBlocID, Size: w.BlocSize})
+	_ = binary.Write(&out, le, formatChunk{
+		AudioFormat:   w.AudioFormat,
+		NbrChannels:   w.NbrChannels,
+		Frequency:     w.Frequency,
+		BytePerSec:    w.BytePerSec,
+		BytePerBloc:   w.BytePerBloc,
+		BitsPerSample: w.BitsPerSample,
+	})
+	if w.BlocSize >= 18 {
+		_ = binary.Write(&out, le, w.CbSize)
+	}
+	switch w.AudioFormat {
+	case AudioFormatExtensible:
+		_ = binary.Write(&out, le, w.ValidBitsPerSample)
+		_ = binary.Write(&out, le, w.ChannelMask)
+		_ = binary.Write(&out, le, w.SubFormat)
+	case AudioFormatIMAADPCM:
+		_ = binary.Write(&out, le, w.SamplesPerBlock)
+	case AudioFormatMSADPCM:
+		_ = binary.Write(&out, le, w.SamplesPerBlock)
+		_ = binary.Write(&out, le, uint16(len(w.Coefs)))
+		_ = binary.Write(&out, le, w.Coefs)
+	}
+
+	if w.hasFact() {
+		_ = binary.Write(&out, le, chunkHeader{ID: w.FactBlocID, Size: w.FactSize})
+		_ = binary.Write(&out, le, w.SampleLength)
+	}
+
+	out.Write(w.metadata)
+
+	_ = binary.Write(&out, le, chunkHeader{ID: w.DataBlocID, Size: w.DataSize})
+	return out.Bytes()
 }
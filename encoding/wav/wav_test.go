@@ -0,0 +1,696 @@
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/chaimleib/synth/internal/seekbuf"
+	"github.com/chaimleib/synth/pcm"
+)
+
+func TestDecode(t *testing.T) {
+	encodings := []*pcm.Encoder{
+		pcm.New(8000, 1, 1),
+		pcm.New(44100, 2, 1),
+		pcm.New(48000, 2, 2),
+		pcm.New(96000, 3, 2),
+		pcm.New(48000, 4, 1),
+		pcm.NewFloat(48000, 4, 2),
+		pcm.NewFloat(44100, 8, 1),
+		pcm.NewMuLaw(8000, 1),
+		pcm.NewALaw(8000, 2),
+	}
+	for _, enc := range encodings {
+		enc := enc
+		t.Run(fmtName(enc), func(t *testing.T) {
+			src, err := enc.Sine(10*time.Millisecond, 440, 0.5, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := NewEncoderFor(enc).Encode(bytes.NewReader(src.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf, gotEnc, err := Decode(bytes.NewReader(file))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *gotEnc != *enc {
+				t.Errorf("%+v (got) != %+v (expected)", *gotEnc, *enc)
+			}
+			if !bytes.Equal(buf.Bytes(), src.Bytes()) {
+				t.Errorf("decoded samples differ from encoded samples")
+			}
+
+			if enc.Format.IsFloat() || enc.Format.IsCompanded() {
+				d := NewDecoder(bytes.NewReader(file))
+				if _, _, err := d.Decode(); err != nil {
+					t.Fatal(err)
+				}
+				if string(d.WAV.FactBlocID[:]) != "fact" {
+					t.Errorf("missing fact chunk")
+				}
+				if got, expected := int(d.WAV.SampleLength), src.SampleLen(); got != expected {
+					t.Errorf("%d (got) != %d (expected) samples", got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeSkipsUnknownChunks(t *testing.T) {
+	enc := pcm.New(8000, 2, 1)
+	samples := []byte{1, 0, 2, 0, 3, 0}
+
+	var file bytes.Buffer
+	le := binary.LittleEndian
+	file.WriteString("RIFF")
+	_ = binary.Write(&file, le, uint32(0)) // not checked
+	file.WriteString("WAVE")
+
+	// An odd-sized chunk before fmt, which needs a pad byte.
+	file.WriteString("LIST")
+	_ = binary.Write(&file, le, uint32(3))
+	file.WriteString("abc\x00")
+
+	file.WriteString("fmt ")
+	_ = binary.Write(&file, le, uint32(16))
+	_ = binary.Write(&file, le, formatChunk{
+		AudioFormat:   AudioFormatPCM,
+		NbrChannels:   1,
+		Frequency:     8000,
+		BytePerSec:    16000,
+		BytePerBloc:   2,
+		BitsPerSample: 16,
+	})
+
+	file.WriteString("fact")
+	_ = binary.Write(&file, le, uint32(4))
+	_ = binary.Write(&file, le, uint32(3))
+
+	file.WriteString("data")
+	_ = binary.Write(&file, le, uint32(len(samples)))
+	file.Write(samples)
+
+	buf, gotEnc, err := Decode(&file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *gotEnc != *enc {
+		t.Errorf("%+v (got) != %+v (expected)", *gotEnc, *enc)
+	}
+	if !bytes.Equal(buf.Bytes(), samples) {
+		t.Errorf("%v (got) != %v (expected)", buf.Bytes(), samples)
+	}
+}
+
+func TestDecodeHugeChunk(t *testing.T) {
+	// Chunks claiming far more data than the file holds should fail without
+	// allocating it all first.
+	for _, id := range []string{"iXML", "LIST"} {
+		id := id
+		t.Run(id, func(t *testing.T) {
+			var file bytes.Buffer
+			file.WriteString("RIFF")
+			_ = binary.Write(&file, binary.LittleEndian, uint32(0)) // not checked
+			file.WriteString("WAVE")
+			file.WriteString(id)
+			_ = binary.Write(&file, binary.LittleEndian, uint32(0xF0000000))
+			file.WriteString("INFO")
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			_, _, err := Decode(&file)
+			runtime.ReadMemStats(&after)
+			if err == nil {
+				t.Error("expected an error")
+			}
+			if got := after.TotalAlloc - before.TotalAlloc; got > 1<<20 {
+				t.Errorf("%d (got) > %d (expected) bytes allocated", got, 1<<20)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	valid, err := NewEncoder(AudioFormatPCM, 2, 2, 48000).Encode(bytes.NewReader(make([]byte, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		offset int
+		patch  []byte
+	}{
+		{"RIFF", 0, []byte("RIFX")},
+		{"WAVE", 8, []byte("AVI ")},
+		{"AudioFormat", 20, []byte{0x55, 0}},
+		{"BytePerSec", 28, []byte{0, 0, 0, 0}},
+		{"BytePerBloc", 32, []byte{3, 0}},
+		{"BitsPerSample", 34, []byte{12, 0}},
+		{"DataSize", 40, []byte{7, 0, 0, 0}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			file := append([]byte(nil), valid...)
+			copy(file[c.offset:], c.patch)
+			if _, _, err := Decode(bytes.NewReader(file)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func fmtName(enc *pcm.Encoder) string {
+	return fmt.Sprintf(
+		"rate=%d&channels=%d&depth=%d&format=%d",
+		enc.Rate,
+		enc.Channels,
+		enc.Depth,
+		enc.Format,
+	)
+}
+
+func TestWriter(t *testing.T) {
+	enc := pcm.New(8000, 1, 1)
+	samples := []byte{0x80, 0x90, 0xa0, 0x90, 0x80}
+	e := NewEncoder(AudioFormatPCM, enc.Channels, enc.Depth, enc.Rate)
+
+	expected, err := e.Encode(bytes.NewReader(samples))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("seekable", func(t *testing.T) {
+		var out seekbuf.Buffer
+		w, err := e.NewWriter(&out, UnknownSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range samples {
+			if _, err := w.Write([]byte{s}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		d := NewDecoder(bytes.NewReader(out.Bytes()))
+		buf, _, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), samples) {
+			t.Errorf("%v (got) != %v (expected)", buf.Bytes(), samples)
+		}
+		if got, expected := d.WAV.FileSize, uint32(len(out.Bytes())-8); got != expected {
+			t.Errorf("%d (got) != %d (expected) file size", got, expected)
+		}
+	})
+
+	t.Run("non-seekable", func(t *testing.T) {
+		var out bytes.Buffer
+		if _, err := e.NewWriter(&out, UnknownSize); err == nil {
+			t.Errorf("expected an error for an unknown size")
+		}
+
+		out.Reset()
+		w, err := e.NewWriter(&out, int64(len(samples)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(samples[:2]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err == nil {
+			t.Errorf("expected an error for a short write")
+		}
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		buf, _, err := Decode(bytes.NewReader(expected))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), samples) {
+			t.Errorf("%v (got) != %v (expected)", buf.Bytes(), samples)
+		}
+	})
+}
+
+func TestExtensible(t *testing.T) {
+	cases := []struct {
+		name     string
+		enc      *pcm.Encoder
+		layout   pcm.ChannelLayout
+		expected uint16
+	}{
+		{"stereo 16-bit", pcm.New(48000, 2, 2), 0, AudioFormatPCM},
+		{"stereo 24-bit", pcm.New(48000, 3, 2), 0, AudioFormatExtensible},
+		{"tagged stereo", pcm.New(48000, 2, 2), pcm.LayoutStereo, AudioFormatExtensible},
+		{"quad", pcm.New(48000, 2, 4), pcm.LayoutQuad, AudioFormatExtensible},
+		{"5.1 default", pcm.New(48000, 2, 6), 0, AudioFormatExtensible},
+		{"7.1 float", pcm.NewFloat(48000, 4, 8), pcm.Layout7_1, AudioFormatExtensible},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.enc.Layout = c.layout
+			src, err := c.enc.Sine(5*time.Millisecond, 440, 0.5, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := NewEncoderFor(c.enc).Encode(bytes.NewReader(src.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := NewDecoder(bytes.NewReader(file))
+			buf, gotEnc, err := d.Decode()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d.WAV.AudioFormat != c.expected {
+				t.Errorf("%#x (got) != %#x (expected) audio format", d.WAV.AudioFormat, c.expected)
+			}
+			if gotEnc.Format != c.enc.Format || gotEnc.Depth != c.enc.Depth {
+				t.Errorf("%+v (got) != %+v (expected)", *gotEnc, *c.enc)
+			}
+			if got, expected := gotEnc.ChannelLayout(), c.enc.ChannelLayout(); got != expected {
+				t.Errorf("%#x (got) != %#x (expected) layout", got, expected)
+			}
+			if !bytes.Equal(buf.Bytes(), src.Bytes()) {
+				t.Errorf("decoded samples differ from encoded samples")
+			}
+		})
+	}
+}
+
+func TestRF64(t *testing.T) {
+	// Pretend that RIFF files are limited to a few hundred bytes.
+	defer func(limit uint64) { maxRIFFSize = limit }(maxRIFFSize)
+	maxRIFFSize = 200
+
+	enc := pcm.New(8000, 2, 2)
+	small := make([]byte, 40)
+	large := make([]byte, 400)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	copy(small, large)
+
+	cases := []struct {
+		name      string
+		container Container
+		samples   []byte
+		seekable  bool
+		rf64      bool
+		err       error
+	}{
+		{"auto small", ContainerAuto, small, false, false, nil},
+		{"auto large", ContainerAuto, large, false, true, nil},
+		{"auto large seekable", ContainerAuto, large, true, true, nil},
+		{"auto small seekable", ContainerAuto, small, true, false, nil},
+		{"riff small", ContainerRIFF, small, false, false, nil},
+		{"riff large", ContainerRIFF, large, false, false, ErrTooLarge},
+		{"riff large seekable", ContainerRIFF, large, true, false, ErrTooLarge},
+		{"rf64 small", ContainerRF64, small, false, true, nil},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			e := NewEncoderFor(enc)
+			e.Container = c.container
+
+			var (
+				file []byte
+				err  error
+			)
+			if c.seekable {
+				var out seekbuf.Buffer
+				var w *Writer
+				w, err = e.NewWriter(&out, UnknownSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = w.Write(c.samples); err == nil {
+					err = w.Close()
+				}
+				file = out.Bytes()
+			} else {
+				file, err = e.Encode(bytes.NewReader(c.samples))
+			}
+			if err != c.err {
+				t.Fatalf("%v (got) != %v (expected) error", err, c.err)
+			}
+			if err != nil {
+				return
+			}
+
+			d := NewDecoder(bytes.NewReader(file))
+			buf, _, err := d.Decode()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), c.samples) {
+				t.Errorf("decoded samples differ from encoded samples")
+			}
+			if got := d.WAV.isRF64(); got != c.rf64 {
+				t.Errorf("%t (got) != %t (expected) RF64", got, c.rf64)
+			}
+			if c.rf64 {
+				if got, expected := d.WAV.RIFFSize64, uint64(len(file)-8); got != expected {
+					t.Errorf("%d (got) != %d (expected) RIFF size", got, expected)
+				}
+				if got, expected := d.WAV.SampleCount64, uint64(len(c.samples)/4); got != expected {
+					t.Errorf("%d (got) != %d (expected) samples", got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	enc := pcm.New(44100, 2, 1)
+	src, err := enc.Sine(20*time.Millisecond, 440, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := &Metadata{
+		Info: map[string]string{
+			InfoTitle:   "A440",
+			InfoArtist:  "synth",
+			InfoComment: "odd", // exercises the pad byte
+		},
+		Cues: []Cue{
+			{ID: 1, Offset: 0, Label: "start"},
+			{ID: 2, Offset: 100},
+			{ID: 3, Offset: 800, Label: "loop end"},
+		},
+		Sampler: &Sampler{
+			MIDIUnityNote: 69,
+			Loops: []SampleLoop{
+				{CuePointID: 1, Type: LoopForward, Start: 100, End: 800},
+			},
+		},
+		Chunks: []Chunk{
+			{ID: fourCC("LIST"), Data: []byte("exif")},
+			{ID: fourCC("iXML"), Data: []byte("<BWFXML/>")},
+		},
+	}
+
+	e := NewEncoderFor(enc)
+	e.Metadata = meta
+
+	var out seekbuf.Buffer
+	w, err := e.NewWriter(&out, UnknownSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(src.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(bytes.NewReader(out.Bytes()))
+	buf, _, err := d.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), src.Bytes()) {
+		t.Errorf("decoded samples differ from encoded samples")
+	}
+	if got, expected := d.WAV.FileSize, uint32(len(out.Bytes())-8); got != expected {
+		t.Errorf("%d (got) != %d (expected) file size", got, expected)
+	}
+
+	got := d.Metadata
+	if !reflect.DeepEqual(got.Info, meta.Info) {
+		t.Errorf("%v (got) != %v (expected) info", got.Info, meta.Info)
+	}
+	if !reflect.DeepEqual(got.Cues, meta.Cues) {
+		t.Errorf("%+v (got) != %+v (expected) cues", got.Cues, meta.Cues)
+	}
+	if got.Sampler == nil {
+		t.Fatalf("missing sampler")
+	}
+	if got, expected := got.Sampler.SamplePeriod, uint32(1_000_000_000/44100); got != expected {
+		t.Errorf("%d (got) != %d (expected) sample period", got, expected)
+	}
+	if !reflect.DeepEqual(got.Sampler.Loops, meta.Sampler.Loops) {
+		t.Errorf("%+v (got) != %+v (expected) loops", got.Sampler.Loops, meta.Sampler.Loops)
+	}
+	if !reflect.DeepEqual(got.Chunks, meta.Chunks) {
+		t.Errorf("%q (got) != %q (expected) chunks", got.Chunks, meta.Chunks)
+	}
+
+	// Decoded metadata should be written back identically.
+	first, err := e.Encode(bytes.NewReader(src.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Metadata = &got
+	second, err := e.Encode(bytes.NewReader(src.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("re-encoded file differs")
+	}
+}
+
+func TestMetadataInvalid(t *testing.T) {
+	cases := []struct {
+		name string
+		meta *Metadata
+	}{
+		{"duplicate cue", &Metadata{Cues: []Cue{{ID: 1}, {ID: 1}}}},
+		// The second cue gets ID 2, which the first already has.
+		{"assigned cue", &Metadata{Cues: []Cue{{ID: 2}, {}}}},
+		{"long info key", &Metadata{Info: map[string]string{"TITLE": "A440"}}},
+		{"short info key", &Metadata{Info: map[string]string{"NAM": "A440"}}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			e := NewEncoder(AudioFormatPCM, 1, 2, 8000)
+			e.Metadata = c.meta
+			if _, err := e.Encode(bytes.NewReader(make([]byte, 16))); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestBext(t *testing.T) {
+	enc := pcm.New(48000, 2, 2)
+	src, err := enc.Sine(10*time.Millisecond, 440, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bext := &Bext{
+		Description:         "440 Hz reference tone",
+		Originator:          "synth",
+		OriginatorReference: "REF0001",
+		Origination:         time.Date(2024, 3, 15, 10, 30, 45, 0, time.UTC),
+		LoudnessValue:       -2300,
+		MaxTruePeakLevel:    -100,
+		CodingHistory:       "A=PCM,F=48000,W=16,M=stereo\r\n",
+	}
+	bext.SetStart(enc, 10*time.Hour)
+
+	e := NewEncoderFor(enc)
+	e.Metadata = &Metadata{Bext: bext}
+	file, err := e.Encode(bytes.NewReader(src.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(bytes.NewReader(file))
+	if _, _, err := d.Decode(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := d.Metadata.Bext
+	if got == nil {
+		t.Fatalf("missing bext chunk")
+	}
+	expected := *bext
+	expected.Version = 2
+	if !reflect.DeepEqual(*got, expected) {
+		t.Errorf("%+v (got) != %+v (expected)", *got, expected)
+	}
+	if got, expected := got.TimeReference, uint64(10*60*60*48000); got != expected {
+		t.Errorf("%d (got) != %d (expected) time reference", got, expected)
+	}
+	if got, expected := got.SampleTime(enc, 24000), 10*time.Hour+500*time.Millisecond; got != expected {
+		t.Errorf("%s (got) != %s (expected) sample time", got, expected)
+	}
+}
+
+func TestADPCM(t *testing.T) {
+	cases := []struct {
+		audioFormat     int
+		enc             *pcm.Encoder
+		samplesPerBlock uint16
+	}{
+		{AudioFormatIMAADPCM, pcm.New(8000, 2, 1), 505},
+		{AudioFormatIMAADPCM, pcm.New(44100, 2, 2), 2041},
+		{AudioFormatMSADPCM, pcm.New(8000, 2, 1), 500},
+		{AudioFormatMSADPCM, pcm.New(22050, 2, 2), 1012},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(fmt.Sprintf("format=%d&%s", c.audioFormat, fmtName(c.enc)), func(t *testing.T) {
+			src, err := c.enc.Sine(100*time.Millisecond, 440, 0.5, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := NewEncoder(c.audioFormat, c.enc.Channels, c.enc.Depth, c.enc.Rate)
+			file, err := e.Encode(bytes.NewReader(src.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := NewDecoder(bytes.NewReader(file))
+			buf, gotEnc, err := d.Decode()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *gotEnc != *c.enc {
+				t.Errorf("%+v (got) != %+v (expected)", *gotEnc, *c.enc)
+			}
+			h := d.WAV
+			if h.BitsPerSample != 4 || h.SamplesPerBlock != c.samplesPerBlock {
+				t.Errorf(
+					"%d bits, %d samples per block (got) != 4 bits, %d samples per block (expected)",
+					h.BitsPerSample, h.SamplesPerBlock, c.samplesPerBlock,
+				)
+			}
+			if h.DataSize%uint32(h.BytePerBloc) != 0 {
+				t.Errorf("data size %d is not a multiple of block size %d", h.DataSize, h.BytePerBloc)
+			}
+			if got, expected := int(h.SampleLength), src.SampleLen(); got != expected {
+				t.Errorf("%d (got) != %d (expected) samples in fact chunk", got, expected)
+			}
+			if got, expected := buf.SampleLen(), src.SampleLen(); got != expected {
+				t.Fatalf("%d (got) != %d (expected) samples", got, expected)
+			}
+
+			// The compression is lossy, but should track the signal closely.
+			var sqErr, sqSignal float64
+			for i := 0; i < src.SampleLen(); i++ {
+				for ch := 0; ch < c.enc.Channels; ch++ {
+					v := src.ReadFloat(i, ch)
+					diff := buf.ReadFloat(i, ch) - v
+					sqErr += diff * diff
+					sqSignal += v * v
+				}
+			}
+			if snr := 10 * math.Log10(sqSignal/sqErr); snr < 25 {
+				t.Errorf("%.1f dB (got) < 25 dB (expected) signal to noise ratio", snr)
+			}
+		})
+	}
+}
+
+func TestADPCMBlockSize(t *testing.T) {
+	cases := []struct {
+		audioFormat int
+		channels    int
+		blockAlign  uint16
+		ok          bool
+	}{
+		// The smallest blocks that hold a sample after their headers.
+		{AudioFormatIMAADPCM, 1, 4, false},
+		{AudioFormatIMAADPCM, 1, 8, true},
+		{AudioFormatMSADPCM, 1, 7, false},
+		{AudioFormatMSADPCM, 1, 8, true},
+		{AudioFormatMSADPCM, 2, 14, false},
+		{AudioFormatMSADPCM, 2, 15, true},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(fmt.Sprintf("format=%d&channels=%d&blockAlign=%d", c.audioFormat, c.channels, c.blockAlign), func(t *testing.T) {
+			enc := pcm.New(8000, 2, c.channels)
+			src, err := enc.Sine(10*time.Millisecond, 440, 0.5, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := NewEncoder(c.audioFormat, c.channels, 2, 8000)
+			e.BlockAlign = c.blockAlign
+			file, err := e.Encode(bytes.NewReader(src.Bytes()))
+			if !c.ok {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf, _, err := NewDecoder(bytes.NewReader(file)).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, expected := buf.SampleLen(), src.SampleLen(); got != expected {
+				t.Errorf("%d (got) != %d (expected) samples", got, expected)
+			}
+		})
+	}
+}
+
+func TestRF64BogusSize(t *testing.T) {
+	cases := []struct {
+		name        string
+		audioFormat int
+		dataSize    uint64
+	}{
+		{"adpcm", AudioFormatIMAADPCM, 1 << 40},
+		{"pcm beyond int64", AudioFormatPCM, math.MaxInt64 + 1},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			e := NewEncoder(c.audioFormat, 1, 2, 8000)
+			e.Container = ContainerRF64
+			file, err := e.Encode(bytes.NewReader(make([]byte, 2000)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ds64 := bytes.Index(file, []byte("ds64"))
+			binary.LittleEndian.PutUint64(file[ds64+16:], c.dataSize)
+			if _, _, err := Decode(bytes.NewReader(file)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRF64WithoutDS64(t *testing.T) {
+	e := NewEncoder(AudioFormatPCM, 1, 2, 8000)
+	e.Container = ContainerRF64
+	file, err := e.Encode(bytes.NewReader(make([]byte, 2000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Turn the ds64 chunk into one which is skipped.
+	ds64 := bytes.Index(file, []byte("ds64"))
+	copy(file[ds64:], "JUNK")
+	if _, _, err := Decode(bytes.NewReader(file)); err != errNoDS64 {
+		t.Errorf("%v (got) != %v (expected)", err, errNoDS64)
+	}
+}
@@ -0,0 +1,161 @@
+package wav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// UnknownSize may be passed to NewWriter when the amount of audio is not known
+// in advance. The destination must then be an io.WriteSeeker.
+const UnknownSize = -1
+
+var (
+	errNeedSeeker = errors.New("unknown data size requires an io.WriteSeeker")
+	errClosed     = errors.New("write to closed Writer")
+)
+
+// Writer streams audio samples into a WAV file without holding the whole file
+// in memory.
+type Writer struct {
+	w      io.Writer
+	header *WAV
+	// adpcm compresses the samples for ADPCM formats, and is nil otherwise.
+	adpcm *adpcmEncoder
+
+	// start is where the header begins in a seekable destination.
+	start int64
+	// size is the promised number of bytes of samples, or UnknownSize.
+	size    int64
+	written int64
+	// dataSize is the number of bytes in the data chunk, which differs from
+	// written for ADPCM formats.
+	dataSize int64
+	closed   bool
+}
+
+var _ io.WriteCloser = (*Writer)(nil)
+
+// NewWriter writes a WAV header to w and returns a Writer for the samples.
+//
+// If w is an io.WriteSeeker, dataSize may be UnknownSize, and the header is
+// patched with the real sizes on Close. Otherwise, dataSize must be the exact
+// number of bytes of samples that will be written. For ADPCM formats, these
+// are the bytes of 16-bit samples before compression.
+func (e *encoder) NewWriter(w io.Writer, dataSize int64) (*Writer, error) {
+	ww := &Writer{
+		w:    w,
+		size: dataSize,
+	}
+
+	if ws, ok := w.(io.WriteSeeker); ok {
+		start, err := ws.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		ww.start = start
+	} else if dataSize == UnknownSize {
+		return nil, errNeedSeeker
+	}
+
+	if dataSize < UnknownSize {
+		return nil, fmt.Errorf("invalid data size: %d", dataSize)
+	}
+	header, err := e.header(dataSize)
+	if err != nil {
+		return nil, err
+	}
+	ww.header = header
+	if isADPCM(e.AudioFormat) {
+		ww.adpcm = newADPCMEncoder(header)
+	}
+
+	// Write the fixed-length header chunks.
+	if _, err := w.Write(ww.header.encode()); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// Write appends encoded audio samples to the file. For ADPCM formats, the
+// samples are 16-bit PCM, and are compressed a block at a time.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.size != UnknownSize && w.written+int64(len(p)) > w.size {
+		return 0, fmt.Errorf("wrote more than the promised %d bytes", w.size)
+	}
+	if w.adpcm == nil {
+		n, err := w.writeData(p)
+		w.written += int64(n)
+		return n, err
+	}
+
+	if _, err := w.writeData(w.adpcm.write(p)); err != nil {
+		return 0, err
+	}
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+// writeData appends bytes to the data chunk.
+func (w *Writer) writeData(p []byte) (int, error) {
+	// Without room for a ds64 chunk, fail before the sizes wrap around.
+	if w.header.DS64BlocID == [4]byte{} && !w.header.fits(uint64(w.dataSize+int64(len(p)))) {
+		return 0, ErrTooLarge
+	}
+	n, err := w.w.Write(p)
+	w.dataSize += int64(n)
+	return n, err
+}
+
+// Close finishes the data chunk and, for seekable destinations, patches the
+// header with the final sizes. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if w.adpcm != nil {
+		if _, err := w.writeData(w.adpcm.flush()); err != nil {
+			return err
+		}
+	}
+	if w.dataSize%2 != 0 {
+		if _, err := w.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	if w.size != UnknownSize {
+		if w.written != w.size {
+			return fmt.Errorf("wrote %d bytes, but promised %d", w.written, w.size)
+		}
+		return nil
+	}
+
+	if err := w.header.setDataSize(uint64(w.dataSize), w.header.frames(uint64(w.written))); err != nil {
+		return err
+	}
+	return w.patch()
+}
+
+// patch rewrites the header with the final sizes, and then returns to the
+// end of the file.
+func (w *Writer) patch() error {
+	ws := w.w.(io.WriteSeeker)
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := ws.Seek(w.start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := ws.Write(w.header.encode()); err != nil {
+		return err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
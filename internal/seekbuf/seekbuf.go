@@ -0,0 +1,41 @@
+// Package seekbuf provides an in-memory io.WriteSeeker, for testing writers
+// which patch their headers once the size of the data is known.
+package seekbuf
+
+import "io"
+
+// Buffer is an in-memory io.WriteSeeker. Writing past the end extends it.
+type Buffer struct {
+	data []byte
+	pos  int
+}
+
+var _ io.WriteSeeker = (*Buffer)(nil)
+
+// Write writes p at the current position.
+func (b *Buffer) Write(p []byte) (int, error) {
+	if end := b.pos + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+	n := copy(b.data[b.pos:], p)
+	b.pos += n
+	return n, nil
+}
+
+// Seek moves the position for the next Write.
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		b.pos = int(offset)
+	case io.SeekCurrent:
+		b.pos += int(offset)
+	case io.SeekEnd:
+		b.pos = len(b.data) + int(offset)
+	}
+	return int64(b.pos), nil
+}
+
+// Bytes returns everything written so far.
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
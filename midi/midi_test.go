@@ -0,0 +1,183 @@
+package midi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+// smf builds a Standard MIDI File from the bodies of its tracks.
+func smf(format, division int, tracks ...[]byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("MThd")
+	binary.Write(&b, binary.BigEndian, []uint32{6})
+	binary.Write(&b, binary.BigEndian, []uint16{uint16(format), uint16(len(tracks)), uint16(division)})
+	for _, t := range tracks {
+		b.WriteString("MTrk")
+		binary.Write(&b, binary.BigEndian, uint32(len(t)))
+		b.Write(t)
+	}
+	return b.Bytes()
+}
+
+// tempoTrack holds one second per quarter note, and then half a second per
+// quarter note from tick 96.
+var tempoTrack = []byte{
+	0x00, 0xFF, 0x51, 0x03, 0x0F, 0x42, 0x40,
+	0x60, 0xFF, 0x51, 0x03, 0x07, 0xA1, 0x20,
+	0x00, 0xFF, 0x2F, 0x00,
+}
+
+func TestDecode(t *testing.T) {
+	notes := []byte{
+		0x00, 0xC0, 0x08, // program change
+		0x00, 0x90, 0x45, 0x64, // note on
+		0x00, 0xF0, 0x02, 0x7E, 0xF7, // sysex, which cancels running status
+		0x00, 0xB0, 0x07, 0x50, // volume
+		0x20, 0xE0, 0x00, 0x60, // pitch bend
+		0x81, 0x40, 0x80, 0x45, 0x00, // note off after a two-byte delta
+		0x00, 0x90, 0x48, 0x40, // note on
+		0x10, 0x48, 0x00, // note off by running status
+		0x00, 0xFF, 0x2F, 0x00,
+	}
+	f, err := Decode(bytes.NewReader(smf(1, 96, tempoTrack, notes)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Format != 1 || f.Division != 96 || len(f.Tracks) != 2 {
+		t.Fatalf("%d, %d, %d (got) != 1, 96, 2 (expected)", f.Format, f.Division, len(f.Tracks))
+	}
+
+	expected := Track{
+		{Tick: 0, Type: ProgramChange, Value: 8},
+		{Tick: 0, Type: NoteOn, Number: 69, Value: 100},
+		{Tick: 0, Type: ControlChange, Number: CCVolume, Value: 80},
+		{Tick: 32, Type: PitchBend, Value: 4096},
+		{Tick: 224, Type: NoteOff, Number: 69},
+		{Tick: 224, Type: NoteOn, Number: 72, Value: 64},
+		{Tick: 240, Type: NoteOff, Number: 72},
+	}
+	if !reflect.DeepEqual(f.Tracks[1], expected) {
+		t.Errorf("%+v (got) != %+v (expected)", f.Tracks[1], expected)
+	}
+
+	m := f.TempoMap()
+	for tick, expected := range map[int64]time.Duration{
+		0:   0,
+		48:  500 * time.Millisecond,
+		96:  time.Second,
+		144: 1250 * time.Millisecond,
+		240: 1750 * time.Millisecond,
+	} {
+		if got := m.Time(tick); got != expected {
+			t.Errorf("tick %d: %v (got) != %v (expected)", tick, got, expected)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	cases := map[string][]byte{
+		"not smf":       []byte("RIFF\x00\x00\x00\x06\x00\x00\x00\x01\x00\x60"),
+		"format 2":      smf(2, 96, tempoTrack),
+		"no status":     smf(0, 96, []byte{0x00, 0x45, 0x64}),
+		"truncated":     smf(0, 96, []byte{0x00, 0x90, 0x45}),
+		"long quantity": smf(0, 96, []byte{0x80, 0x80, 0x80, 0x80, 0x00}),
+		"smpte -128":    smf(0, 0x8001, tempoTrack),
+		"smpte -23":     smf(0, 0xE901, tempoTrack),
+	}
+	for name, file := range cases {
+		file := file
+		t.Run(name, func(t *testing.T) {
+			if _, err := Decode(bytes.NewReader(file)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRenderBandLimited(t *testing.T) {
+	// An organ plays a square wave for a quarter second.
+	track := append(tempoTrack[:7:7],
+		0x00, 0xC0, 0x10,
+		0x00, 0x90, 0x45, 0x7F,
+		0x18, 0x80, 0x45, 0x00,
+		0x00, 0xFF, 0x2F, 0x00,
+	)
+	f, err := Decode(bytes.NewReader(smf(0, 96, track)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// maxStep returns the largest change between samples while the note is
+	// held. The edges of a naive square wave jump in a single sample.
+	maxStep := func(bandLimited bool) float64 {
+		enc := pcm.NewFloat(48000, 8, 1)
+		enc.BandLimited = bandLimited
+		buf, err := Render(f, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var step float64
+		for i := 1; i < enc.Rate/4; i++ {
+			step = math.Max(step, math.Abs(buf.ReadFloat(i, 0)-buf.ReadFloat(i-1, 0)))
+		}
+		return step
+	}
+	if naive, smooth := maxStep(false), maxStep(true); smooth >= 0.75*naive {
+		t.Errorf("%v (got) >= %v (expected) largest step", smooth, 0.75*naive)
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name      string
+		bend      []byte
+		frequency float64
+	}{
+		{"a4", nil, 440},
+		{"bent", []byte{0x00, 0xE0, 0x7F, 0x7F}, 440 * math.Pow(2, (2*8191.0/8192)/12)},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// A sine for one second, at one second per quarter note.
+			track := []byte{0x00, 0xC0, 0x08}
+			track = append(track, tt.bend...)
+			track = append(track,
+				0x00, 0x90, 0x45, 0x7F,
+				0x60, 0x80, 0x45, 0x00,
+				0x00, 0xFF, 0x2F, 0x00,
+			)
+			f, err := Decode(bytes.NewReader(smf(0, 96, append(tempoTrack[:7:7], track...))))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			enc := pcm.New(48000, 2, 1)
+			buf, err := Render(f, enc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedLen := enc.SamplesForDuration(time.Second + release)
+			if got := buf.SampleLen(); got != expectedLen {
+				t.Errorf("%d (got) != %d (expected) samples", got, expectedLen)
+			}
+
+			// Count the cycles while the note is held.
+			crossings := 0
+			for i := 1; i < enc.Rate; i++ {
+				if buf.ReadValue(i-1, 0) < 0 && buf.ReadValue(i, 0) >= 0 {
+					crossings++
+				}
+			}
+			if math.Abs(float64(crossings)-tt.frequency) > 1 {
+				t.Errorf("%d (got) != %.1f (expected) cycles", crossings, tt.frequency)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package midi
+
+import (
+	"math"
+	"time"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+const (
+	// gain scales every voice, leaving headroom for chords.
+	gain = 0.25
+	// bendRange is how many semitones a full pitch bend moves a note.
+	bendRange = 2
+	attack    = 5 * time.Millisecond
+	release   = 50 * time.Millisecond
+
+	// drumChannel plays percussion instead of pitched notes, as in General
+	// MIDI. Drums are rendered as bursts of noise.
+	drumChannel  = 9
+	drumLength   = 150 * time.Millisecond
+	drumHalfLife = 30 * time.Millisecond
+)
+
+// oscillator is the signature of the pcm.Encoder waveform generators.
+type oscillator func(enc *pcm.Encoder, duration time.Duration, frequency, amplitude, phase float64) (*pcm.Buffer, error)
+
+// familyWaveforms picks a waveform for each family of eight General MIDI
+// programs.
+var familyWaveforms = [16]oscillator{
+	(*pcm.Encoder).Triangle, // piano
+	(*pcm.Encoder).Sine,     // chromatic percussion
+	(*pcm.Encoder).Square,   // organ
+	(*pcm.Encoder).Triangle, // guitar
+	(*pcm.Encoder).Triangle, // bass
+	(*pcm.Encoder).Sawtooth, // strings
+	(*pcm.Encoder).Sawtooth, // ensemble
+	(*pcm.Encoder).Sawtooth, // brass
+	(*pcm.Encoder).Square,   // reed
+	(*pcm.Encoder).Sine,     // pipe
+	(*pcm.Encoder).Square,   // synth lead
+	(*pcm.Encoder).Sawtooth, // synth pad
+	(*pcm.Encoder).Sine,     // synth effects
+	(*pcm.Encoder).Triangle, // ethnic
+	(*pcm.Encoder).Triangle, // percussive
+	(*pcm.Encoder).Sine,     // sound effects
+}
+
+// channelState holds the settings of one MIDI channel.
+type channelState struct {
+	program    int
+	volume     int
+	expression int
+	pan        int
+	bend       int
+	sustain    bool
+}
+
+func newChannelState() channelState {
+	return channelState{volume: 100, expression: 127, pan: 64}
+}
+
+// note is a sounding or finished note.
+type note struct {
+	channel  int
+	key      int
+	velocity int
+	program  int
+	// start and end are sample offsets; end is -1 while the note sounds.
+	start, end int
+	// held is set when the note was released while the sustain pedal was
+	// down.
+	held     bool
+	segments []segment
+}
+
+// segment is a stretch of a note with constant settings. A new segment
+// starts whenever a pitch bend or controller changes the note.
+type segment struct {
+	start     int
+	frequency float64
+	amplitude float64
+	// pan ranges from 0 for left to 1 for right.
+	pan float64
+}
+
+// Render plays the File with the pcm.Encoder oscillators, returning a Buffer
+// in the given encoding. Each program family of General MIDI maps to a sine,
+// square, sawtooth or triangle wave. Velocity, volume, expression, pan,
+// sustain and pitch bend are honored.
+func Render(f *File, enc *pcm.Encoder) (*pcm.Buffer, error) {
+	if err := enc.Validate(); err != nil {
+		return nil, err
+	}
+	notes, length := schedule(f, enc)
+	for _, n := range notes {
+		if end := n.start + n.length(enc); end > length {
+			length = end
+		}
+	}
+
+	mix := make([]float64, length*enc.Channels)
+	for _, n := range notes {
+		if err := n.render(mix, enc); err != nil {
+			return nil, err
+		}
+	}
+
+	buf, err := enc.NewBuffer(time.Duration(length) * time.Second / time.Duration(enc.Rate))
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range mix {
+		buf.WriteChanFloat(math.Max(-1, math.Min(1, x)))
+	}
+	return buf, nil
+}
+
+// schedule works out the timing and settings of every note in the File. It
+// returns the notes and the sample offset of the last event.
+func schedule(f *File, enc *pcm.Encoder) ([]*note, int) {
+	tempo := f.TempoMap()
+	var channels [16]channelState
+	for i := range channels {
+		channels[i] = newChannelState()
+	}
+
+	var (
+		notes  []*note
+		active []*note
+		at     int
+	)
+	stop := func(keep func(n *note) bool) {
+		kept := active[:0]
+		for _, n := range active {
+			if keep(n) {
+				kept = append(kept, n)
+			} else {
+				n.end = at
+			}
+		}
+		active = kept
+	}
+	update := func(channel int) {
+		for _, n := range active {
+			if n.channel == channel {
+				n.addSegment(at, &channels[channel])
+			}
+		}
+	}
+
+	for _, e := range f.Events() {
+		at = enc.SamplesForDuration(tempo.Time(e.Tick))
+		ch := &channels[e.Channel]
+		switch e.Type {
+		case NoteOn:
+			n := &note{
+				channel:  e.Channel,
+				key:      e.Number,
+				velocity: e.Value,
+				program:  ch.program,
+				start:    at,
+				end:      -1,
+			}
+			n.addSegment(at, ch)
+			notes = append(notes, n)
+			active = append(active, n)
+		case NoteOff:
+			// Release the oldest matching note.
+			for _, n := range active {
+				if n.channel == e.Channel && n.key == e.Number && !n.held {
+					n.held = true
+					break
+				}
+			}
+			if !ch.sustain {
+				stop(func(n *note) bool { return n.channel != e.Channel || !n.held })
+			}
+		case ControlChange:
+			switch e.Number {
+			case CCVolume:
+				ch.volume = e.Value
+			case CCPan:
+				ch.pan = e.Value
+			case CCExpression:
+				ch.expression = e.Value
+			case CCSustain:
+				ch.sustain = e.Value >= 64
+				if !ch.sustain {
+					stop(func(n *note) bool { return n.channel != e.Channel || !n.held })
+				}
+			case 120, 123: // All Sound Off, All Notes Off
+				stop(func(n *note) bool { return n.channel != e.Channel })
+			}
+			update(e.Channel)
+		case ProgramChange:
+			ch.program = e.Value
+		case PitchBend:
+			ch.bend = e.Value
+			update(e.Channel)
+		}
+	}
+	// Notes still sounding end with the song.
+	stop(func(*note) bool { return false })
+	return notes, at
+}
+
+// addSegment starts a new segment of the note with the current settings of
+// its channel.
+func (n *note) addSegment(at int, ch *channelState) {
+	semitones := float64(n.key-69) + bendRange*float64(ch.bend)/8192
+	s := segment{
+		start:     at,
+		frequency: 440 * math.Pow(2, semitones/12),
+		amplitude: gain * float64(n.velocity*ch.volume*ch.expression) / (127 * 127 * 127),
+		pan:       float64(ch.pan) / 127,
+	}
+	if last := len(n.segments) - 1; last >= 0 && n.segments[last].start == at {
+		n.segments[last] = s
+		return
+	}
+	n.segments = append(n.segments, s)
+}
+
+// length returns the number of samples the note sounds for, including its
+// release.
+func (n *note) length(enc *pcm.Encoder) int {
+	if n.channel == drumChannel {
+		return enc.SamplesForDuration(drumLength)
+	}
+	return n.end - n.start + enc.SamplesForDuration(release)
+}
+
+// render adds the note to the interleaved samples of mix.
+func (n *note) render(mix []float64, enc *pcm.Encoder) error {
+	// Voices are generated at full precision, in mono.
+	voice := pcm.NewFloat(enc.Rate, 8, 1)
+	voice.Source = enc.Source
+	voice.BandLimited = enc.BandLimited
+	releaseLen := enc.SamplesForDuration(release)
+	attackLen := enc.SamplesForDuration(attack)
+	length := n.length(enc)
+
+	envelope := func(i int) float64 {
+		scale := 1.0
+		if i < attackLen {
+			scale = float64(i) / float64(attackLen)
+		}
+		if past := i - (n.end - n.start); past > 0 {
+			scale = math.Min(scale, float64(releaseLen-past)/float64(releaseLen))
+		}
+		return scale
+	}
+	if n.channel == drumChannel {
+		factor := math.Pow(0.5, 1/float64(enc.SamplesForDuration(drumHalfLife)))
+		envelope = func(i int) float64 {
+			return math.Pow(factor, float64(i))
+		}
+	}
+
+	var phase float64
+	for k, s := range n.segments {
+		from := s.start - n.start
+		to := length
+		if k+1 < len(n.segments) {
+			to = n.segments[k+1].start - n.start
+		}
+		if to > length {
+			to = length
+		}
+		if to <= from {
+			continue
+		}
+
+		d := time.Duration(to-from) * time.Second / time.Duration(enc.Rate)
+		var (
+			buf *pcm.Buffer
+			err error
+		)
+		if n.channel == drumChannel {
+			buf, err = voice.WhiteNoise(d, s.amplitude)
+		} else {
+			osc := familyWaveforms[n.program/8]
+			buf, err = osc(voice, d, s.frequency, s.amplitude, phase)
+			phase = math.Mod(phase+2*math.Pi*s.frequency*float64(to-from)/float64(enc.Rate), 2*math.Pi)
+		}
+		if err != nil {
+			return err
+		}
+
+		gains := make([]float64, enc.Channels)
+		for c := range gains {
+			gains[c] = 1
+		}
+		if enc.Channels == 2 {
+			gains[0], gains[1] = math.Cos(s.pan*math.Pi/2), math.Sin(s.pan*math.Pi/2)
+		}
+		for i := from; i < to && i-from < buf.SampleLen(); i++ {
+			x := buf.ReadFloat(i-from, 0) * envelope(i)
+			j := (n.start + i) * enc.Channels
+			for c, g := range gains {
+				mix[j+c] += x * g
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+// Package midi reads Standard MIDI Files and renders them into audio.
+package midi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+const (
+	NoteOff EventType = iota
+	NoteOn
+	ControlChange
+	ProgramChange
+	PitchBend
+	// Tempo is a Set Tempo meta event.
+	Tempo
+)
+
+// Controller numbers understood by the renderer.
+const (
+	CCVolume     = 7
+	CCPan        = 10
+	CCExpression = 11
+	CCSustain    = 64
+)
+
+var (
+	errNotSMF   = errors.New("not a Standard MIDI File")
+	errNoStatus = errors.New("data byte without a running status")
+	errVLQ      = errors.New("variable-length quantity is too long")
+)
+
+// File is a decoded Standard MIDI File. Only the events needed for rendering
+// are kept; system exclusive messages, aftertouch and most meta events are
+// skipped.
+type File struct {
+	// Format is 0 for a single multi-channel track, or 1 for simultaneous
+	// tracks.
+	Format int
+	// Division is the number of ticks per quarter note or, if the top bit is
+	// set, an SMPTE frame rate and the number of ticks per frame.
+	Division uint16
+	Tracks   []Track
+}
+
+// Track is a list of events, in order.
+type Track []Event
+
+// Event is a channel message or a Set Tempo meta event.
+type Event struct {
+	// Tick is the time of the event, in ticks from the start of the track.
+	Tick    int64
+	Type    EventType
+	Channel int
+	// Number is the key of a note event, or the controller of a
+	// ControlChange.
+	Number int
+	// Value is the velocity of a note event, the value of a ControlChange,
+	// the program of a ProgramChange, the bend from -8192 to 8191 of a
+	// PitchBend, or the microseconds per quarter note of a Tempo.
+	Value int
+}
+
+// Decode reads a Standard MIDI File of format 0 or 1 from r.
+func Decode(r io.Reader) (*File, error) {
+	id, header, err := readChunk(r)
+	if err != nil {
+		return nil, err
+	}
+	if id != "MThd" || len(header) < 6 {
+		return nil, errNotSMF
+	}
+	be := binary.BigEndian
+	f := &File{
+		Format:   int(be.Uint16(header)),
+		Division: be.Uint16(header[4:]),
+	}
+	if f.Format != 0 && f.Format != 1 {
+		return nil, fmt.Errorf("unsupported SMF format: %d", f.Format)
+	}
+	if f.Division == 0 || f.Division&0x8000 != 0 && f.Division&0xff == 0 {
+		return nil, fmt.Errorf("invalid division: %#04x", f.Division)
+	}
+	if f.Division&0x8000 != 0 {
+		// SMPTE timing gives the frame rate as a negative byte.
+		switch -int8(f.Division >> 8) {
+		case 24, 25, 29, 30:
+		default:
+			return nil, fmt.Errorf("invalid SMPTE frame rate in division %#04x", f.Division)
+		}
+	}
+
+	ntracks := int(be.Uint16(header[2:]))
+	for len(f.Tracks) < ntracks {
+		id, data, err := readChunk(r)
+		if err != nil {
+			return nil, err
+		}
+		// Skip unknown chunks.
+		if id != "MTrk" {
+			continue
+		}
+		track, err := parseTrack(data)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", len(f.Tracks), err)
+		}
+		f.Tracks = append(f.Tracks, track)
+	}
+	return f, nil
+}
+
+// readChunk reads the ID and data of the next chunk.
+func readChunk(r io.Reader) (string, []byte, error) {
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return "", nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(head[4:]))
+	var data bytes.Buffer
+	if n, err := io.CopyN(&data, r, size); err != nil {
+		if err == io.EOF && n < size {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", nil, err
+	}
+	return string(head[:4]), data.Bytes(), nil
+}
+
+// parseTrack decodes the events of an MTrk chunk.
+func parseTrack(data []byte) (Track, error) {
+	p := &parser{data: data}
+	var (
+		track   Track
+		tick    int64
+		running byte
+	)
+	for p.pos < len(p.data) {
+		delta, err := p.vlq()
+		if err != nil {
+			return nil, err
+		}
+		tick += int64(delta)
+
+		status, err := p.byte()
+		if err != nil {
+			return nil, err
+		}
+		if status < 0x80 {
+			if running == 0 {
+				return nil, errNoStatus
+			}
+			status = running
+			p.pos--
+		}
+
+		switch {
+		case status == 0xFF:
+			running = 0
+			kind, err := p.byte()
+			if err != nil {
+				return nil, err
+			}
+			body, err := p.block()
+			if err != nil {
+				return nil, err
+			}
+			switch kind {
+			case 0x2F: // End of Track
+				return track, nil
+			case 0x51: // Set Tempo
+				if len(body) != 3 {
+					return nil, fmt.Errorf("tempo event has %d bytes, not 3", len(body))
+				}
+				usec := int(body[0])<<16 | int(body[1])<<8 | int(body[2])
+				if usec == 0 {
+					return nil, errors.New("tempo of zero microseconds per quarter note")
+				}
+				track = append(track, Event{Tick: tick, Type: Tempo, Value: usec})
+			}
+		case status == 0xF0 || status == 0xF7:
+			running = 0
+			if _, err := p.block(); err != nil {
+				return nil, err
+			}
+		case status >= 0xF0:
+			return nil, fmt.Errorf("unexpected status byte: %#02x", status)
+		default:
+			running = status
+			e, ok, err := p.channelEvent(status)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				e.Tick = tick
+				track = append(track, e)
+			}
+		}
+	}
+	// Tolerate a missing End of Track event.
+	return track, nil
+}
+
+// parser reads the fields of an MTrk chunk.
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func (p *parser) byte() (byte, error) {
+	if p.pos >= len(p.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := p.data[p.pos]
+	p.pos++
+	return b, nil
+}
+
+// vlq reads a variable-length quantity of up to four bytes.
+func (p *parser) vlq() (uint32, error) {
+	var x uint32
+	for i := 0; i < 4; i++ {
+		b, err := p.byte()
+		if err != nil {
+			return 0, err
+		}
+		x = x<<7 | uint32(b&0x7f)
+		if b&0x80 == 0 {
+			return x, nil
+		}
+	}
+	return 0, errVLQ
+}
+
+// block reads a length-prefixed block of bytes, as found in meta and system
+// exclusive events.
+func (p *parser) block() ([]byte, error) {
+	n, err := p.vlq()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > len(p.data)-p.pos {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := p.data[p.pos : p.pos+int(n)]
+	p.pos += int(n)
+	return b, nil
+}
+
+// channelEvent reads the data bytes of a channel message. It returns false
+// for messages that are skipped.
+func (p *parser) channelEvent(status byte) (Event, bool, error) {
+	size := 2
+	if kind := status & 0xF0; kind == 0xC0 || kind == 0xD0 {
+		size = 1
+	}
+	var d [2]int
+	for i := 0; i < size; i++ {
+		b, err := p.byte()
+		if err != nil {
+			return Event{}, false, err
+		}
+		if b >= 0x80 {
+			return Event{}, false, fmt.Errorf("unexpected status byte in data: %#02x", b)
+		}
+		d[i] = int(b)
+	}
+
+	e := Event{Channel: int(status & 0x0F)}
+	switch status & 0xF0 {
+	case 0x80:
+		e.Type, e.Number, e.Value = NoteOff, d[0], d[1]
+	case 0x90:
+		e.Type, e.Number, e.Value = NoteOn, d[0], d[1]
+		if e.Value == 0 {
+			e.Type = NoteOff
+		}
+	case 0xB0:
+		e.Type, e.Number, e.Value = ControlChange, d[0], d[1]
+	case 0xC0:
+		e.Type, e.Value = ProgramChange, d[0]
+	case 0xE0:
+		e.Type, e.Value = PitchBend, (d[1]<<7|d[0])-8192
+	default:
+		// Aftertouch.
+		return Event{}, false, nil
+	}
+	return e, true, nil
+}
+
+// Events returns the events of all tracks merged into one list, ordered by
+// tick. Simultaneous events keep their track order.
+func (f *File) Events() []Event {
+	var events []Event
+	for _, t := range f.Tracks {
+		events = append(events, t...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Tick < events[j].Tick
+	})
+	return events
+}
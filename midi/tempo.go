@@ -0,0 +1,80 @@
+package midi
+
+import (
+	"sort"
+	"time"
+)
+
+// defaultTempo is 120 beats per minute, in microseconds per quarter note.
+const defaultTempo = 500000
+
+// TempoMap converts ticks into time, following the Set Tempo events of a
+// File.
+type TempoMap struct {
+	// changes are in order of tick, starting at tick 0.
+	changes []tempoChange
+	// ticksPerQuarter is 0 for SMPTE timing, which ignores tempo.
+	ticksPerQuarter int64
+	// ticksPerSecond100 is a hundred times the number of ticks per second,
+	// which represents the 29.97 fps drop-frame rate exactly. It is only used
+	// for SMPTE timing.
+	ticksPerSecond100 int64
+}
+
+// tempoChange starts a stretch of constant tempo.
+type tempoChange struct {
+	tick int64
+	at   time.Duration
+	usec int64 // per quarter note
+}
+
+// TempoMap returns the TempoMap of the File. In a format 1 file, tempo
+// events may appear in any track, though they are usually in the first.
+func (f *File) TempoMap() *TempoMap {
+	m := &TempoMap{}
+	if f.Division&0x8000 != 0 {
+		fps := int64(-int8(f.Division >> 8))
+		fps100 := fps * 100
+		if fps == 29 {
+			fps100 = 2997
+		}
+		m.ticksPerSecond100 = fps100 * int64(f.Division&0xff)
+		return m
+	}
+
+	m.ticksPerQuarter = int64(f.Division)
+	m.changes = []tempoChange{{usec: defaultTempo}}
+	for _, e := range f.Events() {
+		if e.Type != Tempo {
+			continue
+		}
+		last := m.changes[len(m.changes)-1]
+		c := tempoChange{tick: e.Tick, at: m.since(last, e.Tick), usec: int64(e.Value)}
+		if c.tick == last.tick {
+			m.changes[len(m.changes)-1] = c
+		} else {
+			m.changes = append(m.changes, c)
+		}
+	}
+	return m
+}
+
+// Time returns how long after the start of the File the tick occurs.
+func (m *TempoMap) Time(tick int64) time.Duration {
+	if m.ticksPerQuarter == 0 {
+		return time.Duration(tick * 100 * int64(time.Second) / m.ticksPerSecond100)
+	}
+	i := sort.Search(len(m.changes), func(i int) bool {
+		return m.changes[i].tick > tick
+	})
+	return m.since(m.changes[i-1], tick)
+}
+
+// since returns the time of a tick at or after the tempo change c.
+func (m *TempoMap) since(c tempoChange, tick int64) time.Duration {
+	// Split off whole quarter notes to keep the products from overflowing.
+	ticks := tick - c.tick
+	quarters, rem := ticks/m.ticksPerQuarter, ticks%m.ticksPerQuarter
+	quarter := time.Duration(c.usec) * time.Microsecond
+	return c.at + time.Duration(quarters)*quarter + time.Duration(rem)*quarter/time.Duration(m.ticksPerQuarter)
+}
@@ -0,0 +1,95 @@
+package pcm
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+var errDuty = errors.New("duty cycle must be between 0 and 1")
+
+// blepShape returns the level of a band-limited waveform, from -1 to 1, at
+// phase u of its period, from 0 to 1. Each sample advances the phase by dt.
+type blepShape func(u, dt float64) float64
+
+// bandLimited generates a waveform whose discontinuities have been smoothed
+// by shape.
+func (enc *Encoder) bandLimited(duration time.Duration, frequency, amplitude, phase float64, shape blepShape) (*Buffer, error) {
+	buf, err := enc.NewBuffer(duration)
+	if err != nil {
+		return nil, err
+	}
+
+	dt := frequency / float64(enc.Rate)
+	u0 := phase / (2 * math.Pi)
+	for i := 0; i < enc.SamplesForDuration(duration); i++ {
+		x := amplitude * shape(frac(float64(i)*dt+u0), dt)
+		for c := 0; c < enc.Channels; c++ {
+			buf.WriteChanFloat(x)
+		}
+	}
+	return buf, nil
+}
+
+// frac returns the fractional part of x, from 0 to 1, even for negative x.
+func frac(x float64) float64 {
+	return x - math.Floor(x)
+}
+
+// polyBLEP is the difference between a band-limited and a naive upward step
+// of 2 at phase 0, at a sample at phase t. It is nonzero only for the samples
+// within dt of the step.
+func polyBLEP(t, dt float64) float64 {
+	switch {
+	case t < dt:
+		t /= dt
+		return 2*t - t*t - 1
+	case t > 1-dt:
+		t = (t - 1) / dt
+		return t*t + 2*t + 1
+	}
+	return 0
+}
+
+// polyBLAMP is the integral of polyBLEP: the difference between a
+// band-limited and a naive corner at phase 0, where the slope rises by 2 per
+// sample.
+func polyBLAMP(t, dt float64) float64 {
+	switch {
+	case t < dt:
+		x := 1 - t/dt
+		return x * x * x / 3
+	case t > 1-dt:
+		x := 1 + (t-1)/dt
+		return x * x * x / 3
+	}
+	return 0
+}
+
+// blepPulse rises at phase 0, and falls at the duty cycle.
+func blepPulse(u, dt, duty float64) float64 {
+	x := -1.0
+	if u < duty {
+		x = 1
+	}
+	return x + polyBLEP(u, dt) - polyBLEP(frac(u+1-duty), dt)
+}
+
+func blepSquare(u, dt float64) float64 {
+	return blepPulse(u, dt, 0.5)
+}
+
+// blepSawtooth ascends from 0 at phase 0, and falls at phase 0.5, like
+// Sawtooth.
+func blepSawtooth(u, dt float64) float64 {
+	t := frac(u + 0.5)
+	return 2*t - 1 - polyBLEP(t, dt)
+}
+
+// blepTriangle ascends from 0 at phase 0, like Triangle. Its corners are at
+// phases 0.25 and 0.75, where the slope changes by 8dt per sample.
+func blepTriangle(u, dt float64) float64 {
+	v := frac(u + 0.25)
+	x := 4*math.Min(v, 1-v) - 1
+	return x + 4*dt*(polyBLAMP(v, dt)-polyBLAMP(frac(v+0.5), dt))
+}
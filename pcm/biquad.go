@@ -0,0 +1,165 @@
+package pcm
+
+import (
+	"fmt"
+	"math"
+)
+
+// FilterType selects the response of a Biquad.
+type FilterType int
+
+const (
+	LowPass FilterType = iota
+	HighPass
+	// BandPass passes the Frequency at unity gain, with a bandwidth set by
+	// the Q.
+	BandPass
+	Notch
+	// AllPass passes every frequency at unity gain, shifting their phases.
+	AllPass
+	// Peaking boosts or cuts frequencies around the Frequency by the Gain.
+	Peaking
+	// LowShelf boosts or cuts frequencies below the Frequency by the Gain.
+	LowShelf
+	// HighShelf boosts or cuts frequencies above the Frequency by the Gain.
+	HighShelf
+)
+
+// Biquad is a second-order IIR filter, with coefficients from Robert
+// Bristow-Johnson's Audio EQ Cookbook. Its parameters may be changed between
+// blocks. Each channel is filtered separately, and its state is kept from
+// one block to the next.
+type Biquad struct {
+	Type FilterType
+	// Frequency is the cutoff, center or corner frequency, in Hz.
+	Frequency float64
+	// Q sets the resonance or bandwidth. 1/√2 gives the flattest pass band
+	// for LowPass and HighPass, and a shelf without overshoot for LowShelf
+	// and HighShelf.
+	Q float64
+	// Gain is the boost or cut, in decibels, of Peaking, LowShelf and
+	// HighShelf filters. Other types ignore it.
+	Gain float64
+
+	enc *Encoder
+	// params holds the parameters that the coefficients were computed for,
+	// once ready.
+	params             [4]float64
+	ready              bool
+	b0, b1, b2, a1, a2 float64
+	states             []biquadState
+}
+
+var _ Processor = (*Biquad)(nil)
+
+// biquadState is the memory of one channel of a transposed direct form II
+// biquad.
+type biquadState struct {
+	z1, z2 float64
+}
+
+// NewBiquad creates a filter for audio in the Encoder's rate and channel
+// count.
+func (enc *Encoder) NewBiquad(t FilterType, frequency, q, gain float64) (*Biquad, error) {
+	f := &Biquad{
+		Type:      t,
+		Frequency: frequency,
+		Q:         q,
+		Gain:      gain,
+		enc:       enc,
+		states:    make([]biquadState, enc.Channels),
+	}
+	if err := f.update(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reset clears the filter's memory of past samples.
+func (f *Biquad) Reset() {
+	for i := range f.states {
+		f.states[i] = biquadState{}
+	}
+}
+
+// Process filters the block in place. If the parameters have been changed to
+// invalid values, the previous ones are kept.
+func (f *Biquad) Process(block []float64) {
+	_ = f.update()
+	channels := len(f.states)
+	for i := 0; i < len(block); i += channels {
+		for c := range f.states {
+			s := &f.states[c]
+			x := block[i+c]
+			y := f.b0*x + s.z1
+			s.z1 = f.b1*x - f.a1*y + s.z2
+			s.z2 = f.b2*x - f.a2*y
+			block[i+c] = y
+		}
+	}
+}
+
+// update recomputes the coefficients if the parameters have changed.
+func (f *Biquad) update() error {
+	params := [4]float64{float64(f.Type), f.Frequency, f.Q, f.Gain}
+	if f.ready && params == f.params {
+		return nil
+	}
+	nyquist := float64(f.enc.Rate) / 2
+	if f.Frequency <= 0 || f.Frequency >= nyquist {
+		return fmt.Errorf("filter frequency %v Hz is not between 0 and %v Hz", f.Frequency, nyquist)
+	}
+	if f.Q <= 0 {
+		return fmt.Errorf("filter Q must be positive, not %v", f.Q)
+	}
+
+	w0 := 2 * math.Pi * f.Frequency / float64(f.enc.Rate)
+	cos, sin := math.Cos(w0), math.Sin(w0)
+	alpha := sin / (2 * f.Q)
+	a := math.Pow(10, f.Gain/40)
+	// sqrtA2Alpha is used by the shelves.
+	sqrtA2Alpha := 2 * math.Sqrt(a) * alpha
+
+	var b0, b1, b2, a0, a1, a2 float64
+	switch f.Type {
+	case LowPass:
+		b0, b1, b2 = (1-cos)/2, 1-cos, (1-cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case HighPass:
+		b0, b1, b2 = (1+cos)/2, -(1 + cos), (1+cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case BandPass:
+		b0, b1, b2 = alpha, 0, -alpha
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case Notch:
+		b0, b1, b2 = 1, -2*cos, 1
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case AllPass:
+		b0, b1, b2 = 1-alpha, -2*cos, 1+alpha
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case Peaking:
+		b0, b1, b2 = 1+alpha*a, -2*cos, 1-alpha*a
+		a0, a1, a2 = 1+alpha/a, -2*cos, 1-alpha/a
+	case LowShelf:
+		b0 = a * ((a + 1) - (a-1)*cos + sqrtA2Alpha)
+		b1 = 2 * a * ((a - 1) - (a+1)*cos)
+		b2 = a * ((a + 1) - (a-1)*cos - sqrtA2Alpha)
+		a0 = (a + 1) + (a-1)*cos + sqrtA2Alpha
+		a1 = -2 * ((a - 1) + (a+1)*cos)
+		a2 = (a + 1) + (a-1)*cos - sqrtA2Alpha
+	case HighShelf:
+		b0 = a * ((a + 1) + (a-1)*cos + sqrtA2Alpha)
+		b1 = -2 * a * ((a - 1) + (a+1)*cos)
+		b2 = a * ((a + 1) + (a-1)*cos - sqrtA2Alpha)
+		a0 = (a + 1) - (a-1)*cos + sqrtA2Alpha
+		a1 = 2 * ((a - 1) - (a+1)*cos)
+		a2 = (a + 1) - (a-1)*cos - sqrtA2Alpha
+	default:
+		return fmt.Errorf("unknown filter type: %d", f.Type)
+	}
+
+	f.b0, f.b1, f.b2 = b0/a0, b1/a0, b2/a0
+	f.a1, f.a2 = a1/a0, a2/a0
+	f.params, f.ready = params, true
+	return nil
+}
@@ -56,3 +56,23 @@ func (b *Buffer) Fadein(duration time.Duration) {
 		}
 	}
 }
+
+// ApplyEnvelope shapes the volume with an Envelope, with note-off at the given
+// time. Audio after the end of the release is silenced.
+func (b *Buffer) ApplyEnvelope(e Envelope, noteOff time.Duration) {
+	g := e.Generator(b.encoder.Rate)
+	off := b.encoder.SamplesForDuration(noteOff)
+	zero := b.encoder.ZeroValue()
+
+	for i := 0; i < b.SampleLen(); i++ {
+		if i == off {
+			g.NoteOff()
+		}
+		scale := g.Next()
+		for channel := 0; channel < b.encoder.Channels; channel++ {
+			value := b.ReadValue(i, channel) - zero
+			value = int(float64(value)*scale) + zero
+			b.WriteValue(value, i, channel)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package pcm
+
+import (
+	"math"
+	"time"
+)
+
+// Modulator is a control signal, such as an envelope, read one sample at a
+// time.
+type Modulator interface {
+	Next() float64
+}
+
+// Curve shapes the stages of an Envelope.
+type Curve int
+
+const (
+	// Linear stages change level at a constant rate.
+	Linear Curve = iota
+	// Exponential stages change quickly at first, and then ease into their
+	// target level, like the charging and discharging capacitor of an analog
+	// envelope.
+	Exponential
+)
+
+// curvature sets how sharply Exponential stages bend. The stage covers
+// 1-e^-curvature, or about 99%, of its distance before being scaled to land
+// exactly on its target.
+const curvature = 5
+
+// Envelope is a DAHDSR volume envelope. After a Delay of silence, it rises to
+// full volume over the Attack, stays there for the Hold, falls to the Sustain
+// level over the Decay, and stays there until note-off. It then falls to
+// silence over the Release. Leave Delay and Hold at zero for a plain ADSR
+// envelope.
+type Envelope struct {
+	Delay   time.Duration
+	Attack  time.Duration
+	Hold    time.Duration
+	Decay   time.Duration
+	Sustain float64
+	Release time.Duration
+	Curve   Curve
+}
+
+// Duration returns how long the envelope lasts, given when note-off happens.
+func (e Envelope) Duration(noteOff time.Duration) time.Duration {
+	return noteOff + e.Release
+}
+
+// envelopeStage is a stage of an EnvelopeGenerator.
+type envelopeStage int
+
+const (
+	stageDelay envelopeStage = iota
+	stageAttack
+	stageHold
+	stageDecay
+	stageSustain
+	stageRelease
+	stageDone
+)
+
+// EnvelopeGenerator produces the levels of an Envelope in real time, one
+// sample at a time, for use as a Modulator.
+type EnvelopeGenerator struct {
+	env  Envelope
+	rate int
+
+	stage envelopeStage
+	// pos is the number of samples into the stage, which lasts length
+	// samples and moves from one level to another.
+	pos, length int
+	from, to    float64
+	level       float64
+}
+
+var _ Modulator = (*EnvelopeGenerator)(nil)
+
+// Generator starts the envelope for audio at the given sample rate. The
+// generator stays in the sustain stage until NoteOff is called.
+func (e Envelope) Generator(rate int) *EnvelopeGenerator {
+	g := &EnvelopeGenerator{env: e, rate: rate}
+	g.enter(stageDelay)
+	return g
+}
+
+// enter starts the given stage, skipping stages that have no length.
+func (g *EnvelopeGenerator) enter(stage envelopeStage) {
+	for {
+		g.stage, g.pos, g.from = stage, 0, g.level
+		var d time.Duration
+		switch stage {
+		case stageDelay:
+			d, g.to = g.env.Delay, 0
+		case stageAttack:
+			d, g.to = g.env.Attack, 1
+		case stageHold:
+			d, g.to = g.env.Hold, 1
+		case stageDecay:
+			d, g.to = g.env.Decay, g.env.Sustain
+		case stageSustain:
+			g.level = g.env.Sustain
+			return
+		case stageRelease:
+			d, g.to = g.env.Release, 0
+		case stageDone:
+			g.level = 0
+			return
+		}
+		if d > 0 {
+			g.length = int(math.Ceil(d.Seconds() * float64(g.rate)))
+			return
+		}
+		g.level = g.to
+		stage++
+	}
+}
+
+// Next returns the level of the next sample, from 0 to 1.
+func (g *EnvelopeGenerator) Next() float64 {
+	switch g.stage {
+	case stageSustain, stageDone:
+		return g.level
+	}
+
+	t := float64(g.pos) / float64(g.length)
+	if g.env.Curve == Exponential {
+		t = (1 - math.Exp(-curvature*t)) / (1 - math.Exp(-curvature))
+	}
+	level := g.from + (g.to-g.from)*t
+	g.level = level
+	g.pos++
+	if g.pos >= g.length {
+		g.level = g.to
+		g.enter(g.stage + 1)
+	}
+	return level
+}
+
+// NoteOff starts the release stage from the current level. It has no effect
+// once the release has started.
+func (g *EnvelopeGenerator) NoteOff() {
+	if g.stage < stageRelease {
+		g.enter(stageRelease)
+	}
+}
+
+// Done reports whether the release has finished.
+func (g *EnvelopeGenerator) Done() bool {
+	return g.stage == stageDone
+}
@@ -0,0 +1,192 @@
+package pcm
+
+import (
+	"fmt"
+	"math"
+)
+
+// Modulated cutoffs are kept within these bounds, as fractions of the sample
+// rate, so that the filters stay stable.
+const (
+	minCutoff = 10.0 / 48000
+	maxCutoff = 0.45
+)
+
+// modulation holds the per-sample parameters shared by SVF and Ladder.
+type modulation struct {
+	Cutoff    float64
+	Resonance float64
+	// CutoffModulator, if not nil, moves the Cutoff by CutoffDepth octaves
+	// per unit, every sample. For example, an EnvelopeGenerator with a depth
+	// of 4 sweeps the cutoff up four octaves and back.
+	CutoffModulator Modulator
+	CutoffDepth     float64
+	// ResonanceModulator, if not nil, is added to the Resonance every
+	// sample.
+	ResonanceModulator Modulator
+}
+
+// next returns the cutoff, as a fraction of the sample rate, and the
+// resonance for the next sample.
+func (m *modulation) next(rate int) (cutoff, resonance float64) {
+	cutoff = m.Cutoff / float64(rate)
+	if m.CutoffModulator != nil {
+		cutoff *= math.Exp2(m.CutoffDepth * m.CutoffModulator.Next())
+	}
+	resonance = m.Resonance
+	if m.ResonanceModulator != nil {
+		resonance += m.ResonanceModulator.Next()
+	}
+	return math.Max(minCutoff, math.Min(maxCutoff, cutoff)), resonance
+}
+
+// validCutoff returns an error unless the cutoff is below the Nyquist
+// frequency.
+func (enc *Encoder) validCutoff(cutoff float64) error {
+	nyquist := float64(enc.Rate) / 2
+	if cutoff <= 0 || cutoff >= nyquist {
+		return fmt.Errorf("filter cutoff %v Hz is not between 0 and %v Hz", cutoff, nyquist)
+	}
+	return nil
+}
+
+// SVF is a state-variable filter, using Andrew Simper's trapezoidal
+// integration, which stays stable while its cutoff and resonance are
+// modulated every sample. Its Resonance ranges from 0, for a Q of 0.5, to 1,
+// where it rings for a long time at the cutoff.
+type SVF struct {
+	// Type is LowPass, HighPass, BandPass or Notch. Like a Biquad's, the
+	// BandPass passes the Cutoff at unity gain.
+	Type FilterType
+	modulation
+
+	enc    *Encoder
+	states []svfState
+}
+
+var _ Processor = (*SVF)(nil)
+
+// svfState is the memory of one channel of an SVF: the charges of its two
+// integrators.
+type svfState struct {
+	ic1, ic2 float64
+}
+
+// minDamping keeps an SVF at full Resonance from ringing forever.
+const minDamping = 0.005
+
+// NewSVF creates a state-variable filter for audio in the Encoder's rate and
+// channel count.
+func (enc *Encoder) NewSVF(t FilterType, cutoff, resonance float64) (*SVF, error) {
+	switch t {
+	case LowPass, HighPass, BandPass, Notch:
+	default:
+		return nil, fmt.Errorf("unsupported state-variable filter type: %d", t)
+	}
+	if err := enc.validCutoff(cutoff); err != nil {
+		return nil, err
+	}
+	return &SVF{
+		Type:       t,
+		modulation: modulation{Cutoff: cutoff, Resonance: resonance},
+		enc:        enc,
+		states:     make([]svfState, enc.Channels),
+	}, nil
+}
+
+// Process filters the block in place.
+func (f *SVF) Process(block []float64) {
+	channels := len(f.states)
+	for i := 0; i < len(block); i += channels {
+		cutoff, resonance := f.next(f.enc.Rate)
+		g := math.Tan(math.Pi * cutoff)
+		k := math.Max(minDamping, 2*(1-resonance))
+		a1 := 1 / (1 + g*(g+k))
+		a2 := g * a1
+		a3 := g * a2
+
+		for c := range f.states {
+			s := &f.states[c]
+			v0 := block[i+c]
+			v3 := v0 - s.ic2
+			v1 := a1*s.ic1 + a2*v3
+			v2 := s.ic2 + a2*s.ic1 + a3*v3
+			s.ic1 = 2*v1 - s.ic1
+			s.ic2 = 2*v2 - s.ic2
+
+			low, band := v2, v1
+			high := v0 - k*v1 - v2
+			switch f.Type {
+			case LowPass:
+				block[i+c] = low
+			case HighPass:
+				block[i+c] = high
+			case BandPass:
+				block[i+c] = k * band
+			case Notch:
+				block[i+c] = low + high
+			}
+		}
+	}
+}
+
+// Ladder is a Moog-style four-pole low-pass ladder filter. It is solved with
+// zero-delay feedback, so that its cutoff and resonance stay accurate at high
+// frequencies, and its input saturates, so that above a Resonance of 1 it
+// self-oscillates at its cutoff without running away. Like the original,
+// raising the Resonance thins out the pass band.
+type Ladder struct {
+	modulation
+
+	enc *Encoder
+	// states holds the integrator of each stage, for each channel.
+	states [][4]float64
+}
+
+var _ Processor = (*Ladder)(nil)
+
+// NewLadder creates a ladder filter for audio in the Encoder's rate and
+// channel count.
+func (enc *Encoder) NewLadder(cutoff, resonance float64) (*Ladder, error) {
+	if err := enc.validCutoff(cutoff); err != nil {
+		return nil, err
+	}
+	return &Ladder{
+		modulation: modulation{Cutoff: cutoff, Resonance: resonance},
+		enc:        enc,
+		states:     make([][4]float64, enc.Channels),
+	}, nil
+}
+
+// Process filters the block in place.
+func (f *Ladder) Process(block []float64) {
+	channels := len(f.states)
+	for i := 0; i < len(block); i += channels {
+		cutoff, resonance := f.next(f.enc.Rate)
+		g := math.Tan(math.Pi * cutoff)
+		// Each stage is a trapezoidal one-pole, whose output is G times its
+		// input plus beta times its state.
+		G, beta := g/(1+g), 1/(1+g)
+		G4 := G * G * G * G
+		k := 4 * math.Max(0, resonance)
+
+		for c := range f.states {
+			s := &f.states[c]
+			// Solve for the output that is fed back, before saturating.
+			var sum float64
+			for _, state := range s {
+				sum = sum*G + beta*state
+			}
+			x := block[i+c]
+			y := (G4*x + sum) / (1 + k*G4)
+
+			u := math.Tanh(x - k*y)
+			for j := range s {
+				v := (u - s[j]) * G
+				u = v + s[j]
+				s[j] = u + v
+			}
+			block[i+c] = u
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pcm
+
+// G.711 companding squeezes 14-bit (mu-law) or 13-bit (A-law) linear samples
+// into 8 bits with logarithmic steps. Linear values here use a 16-bit scale.
+// Source: ITU-T Recommendation G.711, and Sun Microsystems' reference g711.c.
+
+const (
+	muLawBias = 0x84
+	muLawClip = 8159
+)
+
+var (
+	muLawTable [256]int16
+	aLawTable  [256]int16
+)
+
+func init() {
+	for i := range muLawTable {
+		muLawTable[i] = decodeMuLaw(byte(i))
+		aLawTable[i] = decodeALaw(byte(i))
+	}
+}
+
+// segment returns the index of the first segment end which v doesn't exceed,
+// or len(ends) if it exceeds them all.
+func segment(v int, ends []int) int {
+	for i, end := range ends {
+		if v <= end {
+			return i
+		}
+	}
+	return len(ends)
+}
+
+var (
+	muLawEnds = []int{0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF, 0x1FFF}
+	aLawEnds  = []int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+)
+
+// encodeMuLaw compresses a 16-bit linear value to mu-law.
+func encodeMuLaw(value int16) byte {
+	v := int(value) >> 2
+	mask := byte(0xFF)
+	if v < 0 {
+		v = -v
+		mask = 0x7F
+	}
+	if v > muLawClip {
+		v = muLawClip
+	}
+	v += muLawBias >> 2
+
+	seg := segment(v, muLawEnds)
+	if seg >= 8 {
+		return 0x7F ^ mask
+	}
+	return byte(seg<<4|(v>>(seg+1))&0xF) ^ mask
+}
+
+// decodeMuLaw expands a mu-law value to a 16-bit linear value.
+func decodeMuLaw(u byte) int16 {
+	u = ^u
+	t := (int(u&0xF) << 3) + muLawBias
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(muLawBias - t)
+	}
+	return int16(t - muLawBias)
+}
+
+// encodeALaw compresses a 16-bit linear value to A-law.
+func encodeALaw(value int16) byte {
+	v := int(value) >> 3
+	mask := byte(0xD5)
+	if v < 0 {
+		v = -v - 1
+		mask = 0x55
+	}
+
+	seg := segment(v, aLawEnds)
+	if seg >= 8 {
+		return 0x7F ^ mask
+	}
+	a := seg << 4
+	if seg < 2 {
+		a |= (v >> 1) & 0xF
+	} else {
+		a |= (v >> seg) & 0xF
+	}
+	return byte(a) ^ mask
+}
+
+// decodeALaw expands an A-law value to a 16-bit linear value.
+func decodeALaw(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0xF) << 4
+	switch seg := (a & 0x70) >> 4; seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+// clip16 limits an audio level to the range of an int16.
+func clip16(value int) int16 {
+	if value > 0x7FFF {
+		return 0x7FFF
+	} else if value < -0x8000 {
+		return -0x8000
+	}
+	return int16(value)
+}
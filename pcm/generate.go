@@ -10,6 +10,9 @@ import (
 // NewSilence creates a zeroed-out buffer lasting for the given duration of
 // audio.
 func (enc *Encoder) NewSilence(d time.Duration) (*Buffer, error) {
+	if err := enc.Validate(); err != nil {
+		return nil, err
+	}
 	b := &Buffer{
 		encoder: enc,
 	}
@@ -18,9 +21,16 @@ func (enc *Encoder) NewSilence(d time.Duration) (*Buffer, error) {
 		return nil, err
 	}
 	b.data = make([]byte, l)
-	if zero := enc.ZeroValue(); zero != 0 {
-		for i := range b.data {
-			b.data[i] = byte(zero)
+
+	// Fill with the at-rest value, unless it is stored as zero bytes.
+	rest := make([]byte, enc.Depth)
+	b.encodeValue(rest, enc.ZeroValue())
+	for _, v := range rest {
+		if v != 0 {
+			for i := range b.data {
+				b.data[i] = rest[i%enc.Depth]
+			}
+			break
 		}
 	}
 	return b, nil
@@ -35,9 +45,24 @@ func init() {
 	}
 }
 
+// random returns a random number generator drawing from the Encoder's
+// Source, or else from a pooled clock-seeded source. Call release when done
+// with it.
+func (enc *Encoder) random() (r *rand.Rand, release func()) {
+	if enc.Source != nil {
+		return rand.New(enc.Source), func() {}
+	}
+	r = randPool.Get().(*rand.Rand)
+	return r, func() { randPool.Put(r) }
+}
+
 // WhiteNoise creates a buffer of evenly-distributed random noise
-// lasting for the given duration of audio.
+// lasting for the given duration of audio. Set the Encoder's Source to make
+// the noise reproducible.
 func (enc *Encoder) WhiteNoise(d time.Duration, amplitude float64) (*Buffer, error) {
+	if err := enc.Validate(); err != nil {
+		return nil, err
+	}
 	b := &Buffer{
 		encoder: enc,
 	}
@@ -49,26 +74,25 @@ func (enc *Encoder) WhiteNoise(d time.Duration, amplitude float64) (*Buffer, err
 	}
 	b.data = make([]byte, l)
 
-	// fill the buffer with random bytes
-	{ // Scope the r so that we don't use it after we've returned it to the pool.
-		r := randPool.Get().(*rand.Rand)
-		_, _ = r.Read(b.data) // always returns nil error
-		randPool.Put(r)
-	}
-
-	// scale the random values and re-center if needed
-	zero := enc.ZeroValue()
+	// Draw the levels directly, rather than filling the buffer with random
+	// bytes: for float formats, those would include NaNs and infinities; for
+	// G.711 formats, they would cluster near silence; and for integer
+	// formats, scaling them would round towards zero unevenly.
+	r, release := enc.random()
 	for i := 0; i < b.SampleLen(); i++ {
 		for c := 0; c < enc.Channels; c++ {
-			x := int(amplitude*float64(b.ReadValue(i, c)-zero)) + zero
-			b.WriteValue(x, i, c)
+			b.WriteFloat(amplitude*(2*r.Float64()-1), i, c)
 		}
 	}
+	release()
 	return b, nil
 }
 
 // Square generates a square wave.
 func (enc *Encoder) Square(duration time.Duration, frequency, amplitude, phase float64) (*Buffer, error) {
+	if enc.BandLimited {
+		return enc.bandLimited(duration, frequency, amplitude, phase, blepSquare)
+	}
 	buf, err := enc.NewBuffer(duration)
 	if err != nil {
 		return nil, err
@@ -97,8 +121,49 @@ func (enc *Encoder) Square(duration time.Duration, frequency, amplitude, phase f
 	return buf, nil
 }
 
+// Pulse generates a pulse wave, which is high for the given duty cycle, from
+// 0 to 1, of each period. A duty cycle of 0.5 gives a square wave.
+func (enc *Encoder) Pulse(duration time.Duration, frequency, amplitude, phase, duty float64) (*Buffer, error) {
+	if duty <= 0 || duty >= 1 {
+		return nil, errDuty
+	}
+	if enc.BandLimited {
+		return enc.bandLimited(duration, frequency, amplitude, phase, func(u, dt float64) float64 {
+			return blepPulse(u, dt, duty)
+		})
+	}
+	buf, err := enc.NewBuffer(duration)
+	if err != nil {
+		return nil, err
+	}
+
+	dt := frequency / float64(enc.Rate)
+	u0 := phase / (2 * math.Pi)
+	maxAmplitude := enc.MaxAmplitude()
+	iAmplitude := int(float64(maxAmplitude) * amplitude)
+	zero := enc.ZeroValue()
+
+	for i := 0; i < enc.SamplesForDuration(duration); i++ {
+		// u is how far we have progressed into the waveform's repeating period.
+		// It ranges from 0 to 1.
+		u := frac(float64(i)*dt + u0)
+		x := iAmplitude
+		if u >= duty {
+			x = -x
+		}
+		for c := 0; c < enc.Channels; c++ {
+			buf.WriteChanSample(x + zero)
+		}
+	}
+
+	return buf, nil
+}
+
 // Sawtooth generates an ascending sawtooth wave.
 func (enc *Encoder) Sawtooth(duration time.Duration, frequency, amplitude, phase float64) (*Buffer, error) {
+	if enc.BandLimited {
+		return enc.bandLimited(duration, frequency, amplitude, phase, blepSawtooth)
+	}
 	buf, err := enc.NewBuffer(duration)
 	if err != nil {
 		return nil, err
@@ -126,6 +191,9 @@ func (enc *Encoder) Sawtooth(duration time.Duration, frequency, amplitude, phase
 
 // Triangle generates a triangle wave.
 func (enc *Encoder) Triangle(duration time.Duration, frequency, amplitude, phase float64) (*Buffer, error) {
+	if enc.BandLimited {
+		return enc.bandLimited(duration, frequency, amplitude, phase, blepTriangle)
+	}
 	buf, err := enc.NewBuffer(duration)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,74 @@
+package pcm
+
+import "math/bits"
+
+// ChannelLayout is a bitmask of the speaker positions which the channels of
+// a sample feed, in channel order. The bits match the WAV channel mask.
+type ChannelLayout uint32
+
+// Speaker positions, in the order that channels are interleaved.
+const (
+	SpeakerFrontLeft ChannelLayout = 1 << iota
+	SpeakerFrontRight
+	SpeakerFrontCenter
+	SpeakerLowFrequency
+	SpeakerBackLeft
+	SpeakerBackRight
+	SpeakerFrontLeftOfCenter
+	SpeakerFrontRightOfCenter
+	SpeakerBackCenter
+	SpeakerSideLeft
+	SpeakerSideRight
+	SpeakerTopCenter
+	SpeakerTopFrontLeft
+	SpeakerTopFrontCenter
+	SpeakerTopFrontRight
+	SpeakerTopBackLeft
+	SpeakerTopBackCenter
+	SpeakerTopBackRight
+)
+
+// Common channel layouts.
+const (
+	LayoutMono   = SpeakerFrontCenter
+	LayoutStereo = SpeakerFrontLeft | SpeakerFrontRight
+	LayoutQuad   = SpeakerFrontLeft | SpeakerFrontRight |
+		SpeakerBackLeft | SpeakerBackRight
+	Layout5_1 = SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter |
+		SpeakerLowFrequency | SpeakerBackLeft | SpeakerBackRight
+	Layout7_1 = SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter |
+		SpeakerLowFrequency | SpeakerBackLeft | SpeakerBackRight |
+		SpeakerSideLeft | SpeakerSideRight
+)
+
+// Channels returns the number of channels in the layout.
+func (l ChannelLayout) Channels() int {
+	return bits.OnesCount32(uint32(l))
+}
+
+// DefaultLayout returns the usual layout for the given number of channels, or
+// zero if there is none.
+func DefaultLayout(channels int) ChannelLayout {
+	switch channels {
+	case 1:
+		return LayoutMono
+	case 2:
+		return LayoutStereo
+	case 4:
+		return LayoutQuad
+	case 6:
+		return Layout5_1
+	case 8:
+		return Layout7_1
+	}
+	return 0
+}
+
+// ChannelLayout returns the Encoder's Layout if set, or else the default
+// layout for its number of channels.
+func (enc *Encoder) ChannelLayout() ChannelLayout {
+	if enc.Layout != 0 {
+		return enc.Layout
+	}
+	return DefaultLayout(enc.Channels)
+}
@@ -0,0 +1,198 @@
+package pcm
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// OverflowPolicy decides how a Mixer handles sums beyond full scale, that is,
+// past MaxAmplitude.
+type OverflowPolicy int
+
+const (
+	// Clip limits each sample to full scale.
+	Clip OverflowPolicy = iota
+	// SoftClip leaves quiet samples alone, and bends louder ones smoothly
+	// towards full scale.
+	SoftClip
+	// Normalize scales the whole mix down so that its peak is at full scale.
+	// Mixes that don't overflow are left alone.
+	Normalize
+)
+
+// softKnee is the level above which SoftClip starts to compress.
+const softKnee = 0.5
+
+// MixIn adds the audio of other, scaled by gain, into the Buffer starting at
+// offset. The Buffer is extended with silence if other runs past its end.
+// Both Buffers must have the same rate and channel count, but may differ in
+// depth and format. Sums past full scale are clipped.
+func (b *Buffer) MixIn(other *Buffer, offset time.Duration, gain float64) error {
+	if err := b.encoder.compatible(other.encoder); err != nil {
+		return err
+	}
+	if offset < 0 {
+		return errNegativeDuration
+	}
+	start := b.encoder.SamplesForDuration(offset)
+	b.extend(start + other.SampleLen())
+	for i := 0; i < other.SampleLen(); i++ {
+		for c := 0; c < b.encoder.Channels; c++ {
+			x := b.ReadFloat(start+i, c) + gain*other.ReadFloat(i, c)
+			b.WriteFloat(math.Max(-1, math.Min(1, x)), start+i, c)
+		}
+	}
+	return nil
+}
+
+// compatible returns an error unless audio in the other encoding can be
+// mixed sample for sample into audio in this one.
+func (enc *Encoder) compatible(other *Encoder) error {
+	if enc.Rate != other.Rate || enc.Channels != other.Channels {
+		return fmt.Errorf(
+			"cannot mix %d channels at %d Hz into %d channels at %d Hz",
+			other.Channels, other.Rate, enc.Channels, enc.Rate,
+		)
+	}
+	return nil
+}
+
+// extend appends silence until the Buffer holds the given number of samples.
+func (b *Buffer) extend(samples int) {
+	for b.SampleLen() < samples {
+		for c := 0; c < b.encoder.Channels; c++ {
+			b.WriteChanFloat(0)
+		}
+	}
+}
+
+// Mixer sums several Buffers into one. The sum is accumulated in float64, so
+// that inputs can exceed full scale before the OverflowPolicy is applied.
+type Mixer struct {
+	Encoder *Encoder
+	Policy  OverflowPolicy
+
+	inputs []mixerInput
+}
+
+// mixerInput is a Buffer added to a Mixer.
+type mixerInput struct {
+	buf   *Buffer
+	start int
+	// gains holds the gain into each output channel, from each input
+	// channel. A mono input contributes to every output channel.
+	gains []float64
+}
+
+// NewMixer creates a Mixer which outputs audio in the given encoding.
+func NewMixer(enc *Encoder, policy OverflowPolicy) *Mixer {
+	return &Mixer{Encoder: enc, Policy: policy}
+}
+
+// Add queues a Buffer to be mixed in, starting at offset. The Buffer must
+// have the Mixer's rate, and either its channel count or a single channel.
+//
+// The pan ranges from -1 for hard left to 1 for hard right, and only applies
+// to stereo output. Mono inputs are panned with equal power, so they are
+// 3 dB quieter in each channel when centered. Stereo inputs are balanced
+// instead, so they are unchanged when centered.
+func (m *Mixer) Add(buf *Buffer, offset time.Duration, gain, pan float64) error {
+	enc, in := m.Encoder, buf.encoder
+	if in.Channels != 1 || in.Rate != enc.Rate {
+		if err := enc.compatible(in); err != nil {
+			return err
+		}
+	}
+	if offset < 0 {
+		return errNegativeDuration
+	}
+	if pan < -1 || pan > 1 {
+		return fmt.Errorf("pan out of range: %v", pan)
+	}
+
+	gains := make([]float64, enc.Channels)
+	for c := range gains {
+		gains[c] = gain
+	}
+	if enc.Channels == 2 {
+		if in.Channels == 1 {
+			theta := (pan + 1) * math.Pi / 4
+			gains[0] *= math.Cos(theta)
+			gains[1] *= math.Sin(theta)
+		} else {
+			gains[0] *= math.Min(1, 1-pan)
+			gains[1] *= math.Min(1, 1+pan)
+		}
+	}
+
+	m.inputs = append(m.inputs, mixerInput{
+		buf:   buf,
+		start: enc.SamplesForDuration(offset),
+		gains: gains,
+	})
+	return nil
+}
+
+// Mix sums the inputs, applies the OverflowPolicy, and returns the result.
+// The result lasts until the end of the last input.
+func (m *Mixer) Mix() (*Buffer, error) {
+	enc := m.Encoder
+	channels := enc.Channels
+	length := 0
+	for _, in := range m.inputs {
+		length = max(length, in.start+in.buf.SampleLen())
+	}
+
+	sum := make([]float64, length*channels)
+	for _, in := range m.inputs {
+		mono := in.buf.encoder.Channels == 1
+		for i := 0; i < in.buf.SampleLen(); i++ {
+			frame := sum[(in.start+i)*channels:]
+			for c, g := range in.gains {
+				src := c
+				if mono {
+					src = 0
+				}
+				frame[c] += g * in.buf.ReadFloat(i, src)
+			}
+		}
+	}
+	m.Policy.apply(sum)
+
+	out, err := enc.NewBuffer(time.Duration(length) * time.Second / time.Duration(enc.Rate))
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range sum {
+		out.WriteChanFloat(x)
+	}
+	return out, nil
+}
+
+// apply brings the samples within full scale.
+func (p OverflowPolicy) apply(samples []float64) {
+	switch p {
+	case SoftClip:
+		for i, x := range samples {
+			if a := math.Abs(x); a > softKnee {
+				y := softKnee + (1-softKnee)*math.Tanh((a-softKnee)/(1-softKnee))
+				samples[i] = math.Copysign(y, x)
+			}
+		}
+	case Normalize:
+		peak := 0.0
+		for _, x := range samples {
+			peak = math.Max(peak, math.Abs(x))
+		}
+		if peak > 1 {
+			for i := range samples {
+				samples[i] /= peak
+			}
+		}
+	}
+	// Clip, and catch rounding past full scale from the other policies.
+	for i, x := range samples {
+		samples[i] = math.Max(-1, math.Min(1, x))
+	}
+}
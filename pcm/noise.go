@@ -0,0 +1,173 @@
+package pcm
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+	"time"
+)
+
+// NoiseColor selects the spectrum of a Noise generator.
+type NoiseColor int
+
+const (
+	// White noise has equal power at every frequency, and is evenly
+	// distributed between -amplitude and amplitude, like WhiteNoise.
+	White NoiseColor = iota
+	// Gaussian is white noise with a normal distribution.
+	Gaussian
+	// Pink noise falls by 3 dB per octave, giving equal power in every
+	// octave. It is made with the Voss-McCartney algorithm.
+	Pink
+	// Brown, or red, noise falls by 6 dB per octave. It is a random walk,
+	// reflected at full scale.
+	Brown
+	// Blue noise rises by 3 dB per octave. It is the difference of pink
+	// noise.
+	Blue
+	// Violet noise rises by 6 dB per octave. It is the difference of white
+	// noise.
+	Violet
+)
+
+// pinkRows is the number of random rows summed by the Voss-McCartney
+// algorithm. Each row changes half as often as the one before, so pink
+// noise extends down to rate / 2^pinkRows.
+const pinkRows = 16
+
+// brownStep is the largest change of a brown noise random walk per sample.
+const brownStep = 1.0 / 16
+
+// noiseRMS is the RMS level of each color before scaling, for uniform white
+// samples from -1 to 1.
+var noiseRMS = map[NoiseColor]float64{
+	Gaussian: 1,
+	// The sum of pinkRows+1 white samples.
+	Pink: math.Sqrt((pinkRows + 1) / 3.0),
+	// A random walk reflected at ±1 is evenly distributed.
+	Brown: 1 / math.Sqrt(3),
+	// Each sample replaces one row and the white sample of pink noise.
+	Blue:   2 / math.Sqrt(3),
+	Violet: math.Sqrt(2.0 / 3),
+}
+
+// Noise generates colored noise indefinitely, a block at a time. Except for
+// White, colors are scaled to an RMS level of a quarter of the Amplitude, and
+// clipped at the Amplitude, which is beyond four standard deviations.
+type Noise struct {
+	Color     NoiseColor
+	Amplitude float64
+	// Correlated plays the same noise in every channel. Otherwise, each
+	// channel is independent.
+	Correlated bool
+
+	enc    *Encoder
+	rand   *rand.Rand
+	states []noiseState
+	reader *Reader
+}
+
+var _ Processor = (*Noise)(nil)
+
+// noiseState is the memory of one channel of noise.
+type noiseState struct {
+	// count selects which pink row to update.
+	count uint32
+	rows  [pinkRows]float64
+	// last is the last sample, before scaling.
+	last float64
+}
+
+// NewNoise creates a Noise generator for audio in the Encoder's rate and
+// channel count. It draws from the Encoder's Source, if set.
+func (enc *Encoder) NewNoise(color NoiseColor, amplitude float64) *Noise {
+	src := enc.Source
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	n := &Noise{
+		Color:     color,
+		Amplitude: amplitude,
+		enc:       enc,
+		rand:      rand.New(src),
+		states:    make([]noiseState, enc.Channels),
+	}
+	// Start the pink rows at random, so that the level doesn't build up
+	// while the slow rows wait for their first change.
+	for i := range n.states {
+		for k := range n.states[i].rows {
+			n.states[i].rows[k] = 2*n.rand.Float64() - 1
+		}
+	}
+	n.reader = enc.NewReader(n)
+	return n
+}
+
+// ColoredNoise creates a buffer of noise of the given color, lasting for the
+// given duration of audio. Each channel is independent.
+func (enc *Encoder) ColoredNoise(d time.Duration, color NoiseColor, amplitude float64) (*Buffer, error) {
+	return enc.Generate(enc.NewNoise(color, amplitude), d)
+}
+
+// Process fills the block with the next samples of noise.
+func (n *Noise) Process(block []float64) {
+	channels := n.enc.Channels
+	scale := n.Amplitude
+	if n.Color != White {
+		scale /= 4 * noiseRMS[n.Color]
+	}
+	for i := 0; i < len(block); i += channels {
+		for c := 0; c < channels; c++ {
+			if n.Correlated && c > 0 {
+				block[i+c] = block[i]
+				continue
+			}
+			x := scale * n.next(&n.states[c])
+			block[i+c] = math.Max(-n.Amplitude, math.Min(n.Amplitude, x))
+		}
+	}
+}
+
+// Read encodes the next samples of noise into p. It never returns io.EOF.
+func (n *Noise) Read(p []byte) (int, error) {
+	return n.reader.Read(p)
+}
+
+// next returns the next unscaled sample of a channel.
+func (n *Noise) next(s *noiseState) float64 {
+	white := 2*n.rand.Float64() - 1
+	var x float64
+	switch n.Color {
+	case White:
+		x = white
+	case Gaussian:
+		x = n.rand.NormFloat64()
+	case Pink, Blue:
+		// Update the row picked by the number of trailing zeros of the
+		// counter, so that row k changes every 2^(k+1) samples.
+		s.count++
+		if k := bits.TrailingZeros32(s.count); k < pinkRows {
+			s.rows[k] = 2*n.rand.Float64() - 1
+		}
+		x = white
+		for _, r := range s.rows {
+			x += r
+		}
+		if n.Color == Blue {
+			x, s.last = x-s.last, x
+			return x
+		}
+	case Brown:
+		x = s.last + brownStep*white
+		if x > 1 {
+			x = 2 - x
+		} else if x < -1 {
+			x = -2 - x
+		}
+	case Violet:
+		x, s.last = white-s.last, white
+		return x
+	}
+	s.last = x
+	return x
+}
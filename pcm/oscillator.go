@@ -0,0 +1,128 @@
+package pcm
+
+import "math"
+
+// Waveform selects the shape of an Oscillator.
+type Waveform int
+
+const (
+	SineWave Waveform = iota
+	SquareWave
+	SawtoothWave
+	TriangleWave
+	// PulseWave is high for the Oscillator's duty cycle, and low for the rest
+	// of each period.
+	PulseWave
+)
+
+// Duty cycles are kept within these bounds, so that a modulated pulse never
+// disappears.
+const (
+	minDuty = 0.01
+	maxDuty = 0.99
+)
+
+// Oscillator generates a waveform indefinitely, a block at a time. Its
+// Frequency and Amplitude may be changed between blocks; the phase carries
+// on smoothly, and the amplitude glides to its new value over the next
+// block. If the Encoder is BandLimited, so is the Oscillator.
+type Oscillator struct {
+	Waveform  Waveform
+	Frequency float64
+	Amplitude float64
+	// Duty is the fraction of each period that a PulseWave is high. It starts
+	// at 0.5, which gives a square wave.
+	Duty float64
+	// DutyModulator, if not nil, is added to the Duty at every sample, for
+	// pulse-width modulation. It is usually an LFO: a slow Oscillator at the
+	// same rate, with an Amplitude of less than 0.5.
+	DutyModulator Modulator
+
+	enc *Encoder
+	// phase is how far the oscillator is into its period, from 0 to 1.
+	phase float64
+	// level is the amplitude at the end of the last block.
+	level  float64
+	reader *Reader
+}
+
+var (
+	_ Processor = (*Oscillator)(nil)
+	_ Modulator = (*Oscillator)(nil)
+)
+
+// NewOscillator creates an Oscillator starting at the given phase, in
+// radians, for audio in the Encoder's rate and channel count.
+func (enc *Encoder) NewOscillator(w Waveform, frequency, amplitude, phase float64) *Oscillator {
+	o := &Oscillator{
+		Waveform:  w,
+		Frequency: frequency,
+		Amplitude: amplitude,
+		Duty:      0.5,
+		enc:       enc,
+		phase:     frac(phase / (2 * math.Pi)),
+		level:     amplitude,
+	}
+	o.reader = enc.NewReader(o)
+	return o
+}
+
+// Process fills the block with the next samples of the waveform, with the
+// same level in every channel.
+func (o *Oscillator) Process(block []float64) {
+	channels := o.enc.Channels
+	samples := len(block) / channels
+	from, to := o.level, o.Amplitude
+	for i := 0; i < samples; i++ {
+		amplitude := from + (to-from)*float64(i+1)/float64(samples)
+		x := amplitude * o.next()
+		for c := 0; c < channels; c++ {
+			block[i*channels+c] = x
+		}
+	}
+	o.level = to
+}
+
+// Next returns the next sample of the waveform, for use as a Modulator such
+// as an LFO. The amplitude is applied without gliding.
+func (o *Oscillator) Next() float64 {
+	o.level = o.Amplitude
+	return o.Amplitude * o.next()
+}
+
+// Read encodes the next samples of the waveform into p, so that it may be
+// streamed indefinitely. It never returns io.EOF.
+func (o *Oscillator) Read(p []byte) (int, error) {
+	return o.reader.Read(p)
+}
+
+// next returns the level of the waveform at the current phase, from -1 to 1,
+// and advances the phase by one sample.
+func (o *Oscillator) next() float64 {
+	dt := o.Frequency / float64(o.enc.Rate)
+	// With dt at zero, the band-limiting corrections vanish.
+	blep := 0.0
+	if o.enc.BandLimited {
+		blep = dt
+	}
+
+	var x float64
+	switch o.Waveform {
+	case SineWave:
+		x = math.Sin(2 * math.Pi * o.phase)
+	case SquareWave:
+		x = blepSquare(o.phase, blep)
+	case SawtoothWave:
+		x = blepSawtooth(o.phase, blep)
+	case TriangleWave:
+		x = blepTriangle(o.phase, blep)
+	case PulseWave:
+		duty := o.Duty
+		if o.DutyModulator != nil {
+			duty += o.DutyModulator.Next()
+		}
+		x = blepPulse(o.phase, blep, math.Max(minDuty, math.Min(maxDuty, duty)))
+	}
+	o.phase = frac(o.phase + dt)
+	return x
+}
@@ -1,7 +1,11 @@
 package pcm
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"time"
 )
 
@@ -10,24 +14,115 @@ const (
 	minDuration = 100 * time.Millisecond
 )
 
+// SampleFormat is how the value of each channel of a sample is stored.
+type SampleFormat int
+
+const (
+	// FormatInt is signed, little-endian integer PCM. It is the zero value.
+	// At a depth of 1, Validate turns it into FormatUint8, so that Encoders
+	// which leave Format unset keep storing 8-bit samples unsigned.
+	FormatInt SampleFormat = iota
+	// FormatUint8 is unsigned 8-bit PCM, which rests at 0x80.
+	FormatUint8
+	// FormatFloat32 is a little-endian IEEE 754 float ranging from -1 to 1.
+	FormatFloat32
+	// FormatFloat64 is a little-endian IEEE 754 double ranging from -1 to 1.
+	FormatFloat64
+	// FormatMuLaw is 8-bit G.711 mu-law, as used in North American and
+	// Japanese telephony. It rests at 0xFF.
+	FormatMuLaw
+	// FormatALaw is 8-bit G.711 A-law, as used in European telephony. It
+	// rests at 0xD5.
+	FormatALaw
+)
+
+// IsFloat reports whether the format stores floating-point values.
+func (f SampleFormat) IsFloat() bool {
+	return f == FormatFloat32 || f == FormatFloat64
+}
+
+// IsCompanded reports whether the format stores logarithmically compressed
+// values, which are expanded to a 16-bit linear scale.
+func (f SampleFormat) IsCompanded() bool {
+	return f == FormatMuLaw || f == FormatALaw
+}
+
 // Encoder specifies how to encode audio into a buffer.
 type Encoder struct {
 	Rate     int
 	Depth    int
 	Channels int
+	Format   SampleFormat
+
+	// Layout is which speakers the channels feed. If zero, the default layout
+	// for the number of channels is assumed.
+	Layout ChannelLayout
+
+	// BandLimited makes Square, Pulse, Sawtooth and Triangle smooth their
+	// corners with PolyBLEP and PolyBLAMP corrections, which suppress the
+	// aliasing of the naive waveforms at high frequencies.
+	BandLimited bool
+
+	// Source, if not nil, supplies the randomness of WhiteNoise and of Noise
+	// generators, instead of a source seeded from the clock. Generators draw
+	// from it in turn, so a source with the same seed, and the same sequence
+	// of calls, give byte-identical audio on every run and machine. It is not
+	// safe for concurrent use.
+	Source rand.Source
 }
 
-// New creates a new Encoder.
+// New creates a new Encoder for integer PCM. A depth of 1 is unsigned, and
+// greater depths are signed.
 func New(rate, depth, channels int) *Encoder {
 	enc := &Encoder{
 		Rate:     rate,
 		Depth:    depth,
 		Channels: channels,
+		Format:   FormatInt,
+	}
+	if depth == 1 {
+		enc.Format = FormatUint8
+	}
+
+	return enc
+}
+
+// NewFloat creates a new Encoder for floating-point samples. The depth must
+// be 4 for 32-bit floats or 8 for 64-bit floats.
+func NewFloat(rate, depth, channels int) *Encoder {
+	enc := &Encoder{
+		Rate:     rate,
+		Depth:    depth,
+		Channels: channels,
+		Format:   FormatFloat32,
+	}
+	if depth == 8 {
+		enc.Format = FormatFloat64
 	}
 
 	return enc
 }
 
+// NewMuLaw creates a new Encoder for G.711 mu-law samples.
+func NewMuLaw(rate, channels int) *Encoder {
+	return &Encoder{
+		Rate:     rate,
+		Depth:    1,
+		Channels: channels,
+		Format:   FormatMuLaw,
+	}
+}
+
+// NewALaw creates a new Encoder for G.711 A-law samples.
+func NewALaw(rate, channels int) *Encoder {
+	return &Encoder{
+		Rate:     rate,
+		Depth:    1,
+		Channels: channels,
+		Format:   FormatALaw,
+	}
+}
+
 // SamplesForDuration returns the number of waveform points which span the
 // given duration. In multichannel audio, a point for each channel counts as a
 // single sample.
@@ -68,18 +163,72 @@ func (enc *Encoder) durationForBytes(length int) time.Duration {
 	return time.Duration(length) * time.Second / time.Duration(enc.Rate*enc.Depth*enc.Channels)
 }
 
+// Validate reports whether the Encoder describes audio that can be encoded.
+// It sets the Format of 8-bit FormatInt Encoders to FormatUint8.
+func (enc *Encoder) Validate() error {
+	if enc.Rate <= 0 {
+		return fmt.Errorf("invalid sample rate: %d", enc.Rate)
+	}
+	if enc.Channels <= 0 {
+		return fmt.Errorf("invalid channel count: %d", enc.Channels)
+	}
+	if enc.Format == FormatInt && enc.Depth == 1 {
+		enc.Format = FormatUint8
+	}
+	switch enc.Format {
+	case FormatInt:
+		if enc.Depth < 1 || enc.Depth > 4 {
+			return fmt.Errorf("unsupported byte depth: %d", enc.Depth)
+		}
+	case FormatUint8:
+		if enc.Depth != 1 {
+			return fmt.Errorf("unsigned samples need a byte depth of 1, not %d", enc.Depth)
+		}
+	case FormatMuLaw, FormatALaw:
+		if enc.Depth != 1 {
+			return fmt.Errorf("G.711 samples need a byte depth of 1, not %d", enc.Depth)
+		}
+	case FormatFloat32:
+		if enc.Depth != 4 {
+			return fmt.Errorf("float32 samples need a byte depth of 4, not %d", enc.Depth)
+		}
+	case FormatFloat64:
+		if enc.Depth != 8 {
+			return fmt.Errorf("float64 samples need a byte depth of 8, not %d", enc.Depth)
+		}
+	default:
+		return fmt.Errorf("unknown sample format: %d", enc.Format)
+	}
+	if enc.Layout != 0 && enc.Layout.Channels() != enc.Channels {
+		return fmt.Errorf(
+			"channel layout has %d channels, not %d",
+			enc.Layout.Channels(), enc.Channels,
+		)
+	}
+	return nil
+}
+
 // MaxAmplitude returns the maximum signal value, based on the Encoder's
-// depth. For example, a byte depth of 1 will yield 127, and a depth
-// of 2 will yield 32767.
+// depth. For example, a byte depth of 1 will yield 127, a depth of 2 will
+// yield 32767, and a depth of 3 will yield 8388607. Float formats use the
+// same scale as a depth of 4, so that integer values of MaxAmplitude are
+// stored as 1.0. G.711 formats use the 16-bit linear scale they expand to.
 func (enc *Encoder) MaxAmplitude() int {
+	if enc.Format.IsFloat() {
+		return math.MaxInt32
+	}
+	if enc.Format.IsCompanded() {
+		return math.MaxInt16
+	}
 	return ^(-1 << (enc.Depth*8 - 1))
 }
 
 // ZeroValue returns the "at-rest" value. For signed types, this is 0; for
 // unsigned types, this is a uint with the greatest bit set, which is
-// half of the maximum amplitude, rounded up.
+// half of the maximum amplitude, rounded up. G.711 formats rest at a linear
+// value of 0, although the byte they store for it isn't 0.
 func (enc *Encoder) ZeroValue() int {
-	if enc.Depth == 1 {
+	if enc.Format == FormatUint8 {
 		return 0x80
 	}
 	return 0
@@ -95,6 +244,9 @@ type Buffer struct {
 // NewBuffer creates an empty buffer with capacity to store the given duration
 // of audio.
 func (enc *Encoder) NewBuffer(d time.Duration) (*Buffer, error) {
+	if err := enc.Validate(); err != nil {
+		return nil, err
+	}
 	b := &Buffer{
 		encoder: enc,
 	}
@@ -135,10 +287,17 @@ func (b *Buffer) allocate(d time.Duration) error {
 // writes it to the Buffer. This must be called for each audio channel to
 // complete a single sample.
 func (b *Buffer) WriteChanSample(x int) {
-	b.data = append(b.data, byte(0xff&x))
-	if b.encoder.Depth == 2 {
-		b.data = append(b.data, byte(0xff&(x>>8)))
-	}
+	i0 := len(b.data)
+	b.data = append(b.data, make([]byte, b.encoder.Depth)...)
+	b.encodeValue(b.data[i0:], x)
+}
+
+// WriteChanFloat is like WriteChanSample, but takes an audio level from -1
+// to 1, regardless of the Buffer's encoding.
+func (b *Buffer) WriteChanFloat(x float64) {
+	i0 := len(b.data)
+	b.data = append(b.data, make([]byte, b.encoder.Depth)...)
+	b.encodeFloat(b.data[i0:], x)
 }
 
 // Reset erases the audio, allowing reuse of a Buffer.
@@ -146,6 +305,9 @@ func (b *Buffer) Reset() {
 	b.data = b.data[:0]
 }
 
+// Encoder returns how the audio is encoded.
+func (b *Buffer) Encoder() *Encoder { return b.encoder }
+
 // Bytes returns the encoded audio.
 func (b *Buffer) Bytes() []byte {
 	return b.data
@@ -168,28 +330,140 @@ func (b *Buffer) ReadValue(i, channel int) int {
 		return 0 // no such value
 	}
 	i0 := i*b.encoder.Depth*b.encoder.Channels + b.encoder.Depth*channel
+	return b.decodeValue(b.data[i0 : i0+b.encoder.Depth])
+}
+
+// WriteValue changes the value of sample number i for the given channel.
+// Assumes that i already exists. If it doesn't, use WriteChanSample instead.
+func (b *Buffer) WriteValue(value, i, channel int) {
+	if i < 0 || channel < 0 || channel >= b.encoder.Channels {
+		return
+	}
+	i0 := i*b.encoder.Depth*b.encoder.Channels + b.encoder.Depth*channel
+	b.encodeValue(b.data[i0:i0+b.encoder.Depth], value)
+}
+
+// ReadFloat is like ReadValue, but returns an audio level from -1 to 1,
+// regardless of the Buffer's encoding.
+func (b *Buffer) ReadFloat(i, channel int) float64 {
+	if i < 0 || channel < 0 || channel >= b.encoder.Channels {
+		return 0 // no such value
+	}
+	i0 := i*b.encoder.Depth*b.encoder.Channels + b.encoder.Depth*channel
+	return b.decodeFloat(b.data[i0 : i0+b.encoder.Depth])
+}
+
+// WriteFloat is like WriteValue, but takes an audio level from -1 to 1,
+// regardless of the Buffer's encoding.
+func (b *Buffer) WriteFloat(x float64, i, channel int) {
+	if i < 0 || channel < 0 || channel >= b.encoder.Channels {
+		return
+	}
+	i0 := i*b.encoder.Depth*b.encoder.Channels + b.encoder.Depth*channel
+	b.encodeFloat(b.data[i0:i0+b.encoder.Depth], x)
+}
+
+// decodeValue converts the bytes of one channel of a sample into an integer
+// audio level.
+func (b *Buffer) decodeValue(p []byte) int {
+	switch b.encoder.Format {
+	case FormatFloat32:
+		f := math.Float32frombits(binary.LittleEndian.Uint32(p))
+		return int(math.Round(float64(f) * math.MaxInt32))
+	case FormatFloat64:
+		f := math.Float64frombits(binary.LittleEndian.Uint64(p))
+		return int(math.Round(f * math.MaxInt32))
+	case FormatMuLaw:
+		return int(muLawTable[p[0]])
+	case FormatALaw:
+		return int(aLawTable[p[0]])
+	}
+
 	var result int
 	// concatenate the bytes
-	for shift := 0; shift < b.encoder.Depth; shift++ {
-		result += int(b.data[i0+shift]) << (8 * shift)
+	for shift := 0; shift < len(p); shift++ {
+		result += int(p[shift]) << (8 * shift)
 	}
 
 	// if representing a signed int, sign-extend
-	if b.encoder.Depth != 1 {
-		mask := 1 << (b.encoder.Depth*8 - 1) // sign bit mask
+	if b.encoder.Format == FormatInt {
+		mask := 1 << (len(p)*8 - 1) // sign bit mask
 		result = (result ^ mask) - mask
 	}
 	return result
 }
 
-// WriteValue changes the value of sample number i for the given channel.
-// Assumes that i already exists. If it doesn't, use WriteChanSample instead.
-func (b *Buffer) WriteValue(value, i, channel int) {
-	if i < 0 || channel < 0 || channel >= b.encoder.Channels {
+// encodeValue stores an integer audio level into the bytes of one channel of
+// a sample.
+func (b *Buffer) encodeValue(p []byte, value int) {
+	switch b.encoder.Format {
+	case FormatFloat32:
+		f := float32(float64(value) / math.MaxInt32)
+		binary.LittleEndian.PutUint32(p, math.Float32bits(f))
+		return
+	case FormatFloat64:
+		f := float64(value) / math.MaxInt32
+		binary.LittleEndian.PutUint64(p, math.Float64bits(f))
+		return
+	case FormatMuLaw:
+		p[0] = encodeMuLaw(clip16(value))
+		return
+	case FormatALaw:
+		p[0] = encodeALaw(clip16(value))
 		return
 	}
-	i0 := i*b.encoder.Depth*b.encoder.Channels + b.encoder.Depth*channel
-	for shift := 0; shift < b.encoder.Depth; shift++ {
-		b.data[i0+shift] = byte(0xff & (value >> (8 * shift)))
+
+	for shift := 0; shift < len(p); shift++ {
+		p[shift] = byte(0xff & (value >> (8 * shift)))
 	}
 }
+
+// decodeFloat converts the bytes of one channel of a sample into an audio
+// level from -1 to 1.
+func (b *Buffer) decodeFloat(p []byte) float64 {
+	switch b.encoder.Format {
+	case FormatFloat32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(p)))
+	case FormatFloat64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(p))
+	}
+	return b.encoder.toFloat(b.decodeValue(p))
+}
+
+// encodeFloat stores an audio level from -1 to 1 into the bytes of one channel
+// of a sample. Float formats store the value as-is; integer formats clip it.
+func (b *Buffer) encodeFloat(p []byte, x float64) {
+	switch b.encoder.Format {
+	case FormatFloat32:
+		binary.LittleEndian.PutUint32(p, math.Float32bits(float32(x)))
+	case FormatFloat64:
+		binary.LittleEndian.PutUint64(p, math.Float64bits(x))
+	default:
+		b.encodeValue(p, b.encoder.fromFloat(x))
+	}
+}
+
+// toFloat converts an integer audio level to the range -1 to 1.
+func (enc *Encoder) toFloat(value int) float64 {
+	return float64(value-enc.ZeroValue()) / float64(enc.MaxAmplitude())
+}
+
+// fromFloat converts an audio level from -1 to 1 into an integer audio
+// level, clipping values that are out of range.
+func (enc *Encoder) fromFloat(x float64) int {
+	max := enc.MaxAmplitude()
+	value := int(math.Round(x * float64(max)))
+	if value > max {
+		value = max
+	} else if value < -max-1 {
+		value = -max - 1
+	}
+	return value + enc.ZeroValue()
+}
+
+// Write appends already-encoded audio to the Buffer. The bytes must match the
+// Buffer's encoding; a trailing partial sample is kept as-is.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
@@ -1,17 +1,25 @@
 package pcm
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
 	"testing"
 	"time"
 )
 
 func Test_ForDuration(t *testing.T) {
 	encodings := []*Encoder{
-		{48000, 1, 1},
-		{48000, 2, 1},
-		{48000, 1, 2},
-		{48000, 2, 2},
+		New(48000, 1, 1),
+		New(48000, 2, 1),
+		New(48000, 1, 2),
+		New(48000, 2, 2),
+		New(48000, 3, 2),
+		New(48000, 4, 2),
+		NewFloat(48000, 4, 2),
+		NewFloat(48000, 8, 1),
 	}
 	for _, enc := range encodings {
 		enc := enc
@@ -67,3 +75,1159 @@ func Test_ForDuration(t *testing.T) {
 		}
 	}
 }
+
+func Test_ChanSampleRoundTrip(t *testing.T) {
+	for depth := 1; depth <= 4; depth++ {
+		depth := depth
+		t.Run(fmt.Sprintf("depth=%d", depth), func(t *testing.T) {
+			enc := New(48000, depth, 2)
+			buf, err := enc.NewBuffer(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			max := enc.MaxAmplitude()
+			zero := enc.ZeroValue()
+			values := []int{0, 1, -1, max, -max, max / 3, -max / 7}
+			for _, v := range values {
+				buf.WriteChanSample(v + zero)
+				buf.WriteChanSample(-v + zero)
+			}
+
+			if got, expected := buf.Len(), 2*len(values)*depth; got != expected {
+				t.Fatalf("%d (got) != %d (expected) bytes", got, expected)
+			}
+			for i, v := range values {
+				if got := buf.ReadValue(i, 0) - zero; got != v {
+					t.Errorf("sample %d, channel 0: %d (got) != %d (expected)", i, got, v)
+				}
+				if got := buf.ReadValue(i, 1) - zero; got != -v {
+					t.Errorf("sample %d, channel 1: %d (got) != %d (expected)", i, got, -v)
+				}
+			}
+		})
+	}
+}
+
+func Test_FloatRoundTrip(t *testing.T) {
+	encodings := []*Encoder{
+		New(48000, 1, 1),
+		New(48000, 2, 1),
+		New(48000, 3, 1),
+		New(48000, 4, 1),
+		NewFloat(48000, 4, 1),
+		NewFloat(48000, 8, 1),
+	}
+	values := []float64{0, 0.5, -0.5, 0.999, -1}
+	for _, enc := range encodings {
+		enc := enc
+		t.Run(fmt.Sprintf("format=%d&depth=%d", enc.Format, enc.Depth), func(t *testing.T) {
+			if err := enc.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			buf, err := enc.NewBuffer(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range values {
+				buf.WriteChanFloat(v)
+			}
+			// Allow for quantization to the encoding's resolution.
+			tolerance := 1.0 / float64(enc.MaxAmplitude())
+			if enc.Format == FormatFloat32 {
+				tolerance = 1e-7
+			}
+			for i, v := range values {
+				got := buf.ReadFloat(i, 0)
+				if diff := got - v; diff > tolerance || diff < -tolerance {
+					t.Errorf("sample %d: %v (got) != %v (expected)", i, got, v)
+				}
+				// Integer access should agree with float access.
+				if gotInt, expected := buf.ReadValue(i, 0), enc.fromFloat(got); gotInt != expected {
+					t.Errorf("sample %d: %d (got) != %d (expected)", i, gotInt, expected)
+				}
+			}
+		})
+	}
+}
+
+func Test_Unsigned8(t *testing.T) {
+	// An Encoder with no Format set has always meant unsigned 8-bit samples.
+	enc := &Encoder{Rate: 8000, Depth: 1, Channels: 1}
+	buf, err := enc.NewSilence(time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc.Format != FormatUint8 {
+		t.Errorf("%d (got) != %d (expected) format", enc.Format, FormatUint8)
+	}
+	for i, b := range buf.Bytes() {
+		if b != 0x80 {
+			t.Fatalf("byte %d: %#x (got) != 0x80 (expected)", i, b)
+		}
+	}
+	if got, expected := enc.ZeroValue(), 0x80; got != expected {
+		t.Errorf("%d (got) != %d (expected) zero value", got, expected)
+	}
+}
+
+func Test_G711(t *testing.T) {
+	cases := []struct {
+		enc     *Encoder
+		silence byte
+	}{
+		{NewMuLaw(8000, 1), 0xFF},
+		{NewALaw(8000, 1), 0xD5},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(fmt.Sprintf("format=%d", c.enc.Format), func(t *testing.T) {
+			silence, err := c.enc.NewSilence(time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, got := range silence.Bytes() {
+				if got != c.silence {
+					t.Fatalf("byte %d: %#x (got) != %#x (expected)", i, got, c.silence)
+				}
+			}
+
+			// Every code should survive expanding and compressing again,
+			// except mu-law's negative zero.
+			buf, err := c.enc.NewBuffer(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for code := 0; code < 256; code++ {
+				_, _ = buf.Write([]byte{byte(code)})
+				v := buf.ReadValue(code, 0)
+				buf.WriteValue(v, code, 0)
+				if got := buf.Bytes()[code]; got != byte(code) && v != 0 {
+					t.Errorf("code %#x: %#x (got) != %#x (expected)", code, got, code)
+				}
+			}
+
+			// Full scale compresses to the loudest codes.
+			buf.Reset()
+			buf.WriteChanFloat(1)
+			buf.WriteChanFloat(-1)
+			if got := buf.ReadFloat(0, 0); got < 0.97 {
+				t.Errorf("%v (got) < 0.97 (expected)", got)
+			}
+			if got := buf.ReadFloat(1, 0); got > -0.97 {
+				t.Errorf("%v (got) > -0.97 (expected)", got)
+			}
+		})
+	}
+}
+
+func Test_RawLayout(t *testing.T) {
+	cases := []struct {
+		enc      *Encoder
+		values   []int
+		layout   RawLayout
+		expected []byte
+	}{
+		{
+			New(8000, 2, 2), []int{1, -2, 0x1234, -0x8000},
+			RawLayout{},
+			[]byte{0x01, 0x00, 0xFE, 0xFF, 0x34, 0x12, 0x00, 0x80},
+		},
+		{
+			New(8000, 2, 2), []int{1, -2, 0x1234, -0x8000},
+			RawLayout{BigEndian: true, Planar: true},
+			[]byte{0x00, 0x01, 0x12, 0x34, 0xFF, 0xFE, 0x80, 0x00},
+		},
+		{
+			New(8000, 2, 2), []int{1, -2, 0x1234, -0x8000},
+			RawLayout{BigEndian: true, Sign: Unsigned},
+			[]byte{0x80, 0x01, 0x7F, 0xFE, 0x92, 0x34, 0x00, 0x00},
+		},
+		{
+			New(8000, 3, 1), []int{0x123456, -1},
+			RawLayout{BigEndian: true},
+			[]byte{0x12, 0x34, 0x56, 0xFF, 0xFF, 0xFF},
+		},
+		{
+			New(8000, 1, 2), []int{0x80, 0x81, 0x7F, 0x00},
+			RawLayout{Sign: Signed, Planar: true},
+			[]byte{0x00, 0xFF, 0x01, 0x80},
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(fmt.Sprintf("depth=%d&layout=%+v", c.enc.Depth, c.layout), func(t *testing.T) {
+			buf, err := c.enc.NewBuffer(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range c.values {
+				buf.WriteChanSample(v)
+			}
+			got := buf.Export(c.layout)
+			if !bytes.Equal(got, c.expected) {
+				t.Errorf("%x (got) != %x (expected)", got, c.expected)
+			}
+
+			back, err := FromBytes(c.enc, got, c.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(back.Bytes(), buf.Bytes()) {
+				t.Errorf("%x (got) != %x (expected)", back.Bytes(), buf.Bytes())
+			}
+		})
+	}
+
+	if _, err := FromBytes(New(8000, 2, 2), make([]byte, 6), RawLayout{}); err == nil {
+		t.Error("expected an error for a partial sample")
+	}
+}
+
+func Test_MixIn(t *testing.T) {
+	enc := New(1000, 2, 2)
+	b, err := enc.Sine(10*time.Millisecond, 100, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := enc.Sine(10*time.Millisecond, 100, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MixIn(other, 5*time.Millisecond, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := b.SampleLen(), 15; got != expected {
+		t.Fatalf("%d (got) != %d (expected) samples", got, expected)
+	}
+	for i := 0; i < b.SampleLen(); i++ {
+		expected := 0.0
+		if i < 10 {
+			expected += other.ReadFloat(i, 0)
+		}
+		if i >= 5 {
+			expected += 2 * other.ReadFloat(i-5, 0)
+		}
+		expected = math.Max(-1, math.Min(1, expected))
+		for c := 0; c < enc.Channels; c++ {
+			if got := b.ReadFloat(i, c); math.Abs(got-expected) > 1e-4 {
+				t.Errorf("sample %d: %v (got) != %v (expected)", i, got, expected)
+			}
+		}
+	}
+
+	if err := b.MixIn(other, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	mono, err := New(1000, 2, 1).Sine(time.Millisecond, 100, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MixIn(mono, 0, 1); err == nil {
+		t.Error("expected an error mixing mono into stereo")
+	}
+}
+
+func Test_Mixer(t *testing.T) {
+	// level returns a mono or stereo buffer of two samples at a constant
+	// level.
+	level := func(channels int, x float64) *Buffer {
+		b, _ := NewFloat(1000, 8, channels).NewBuffer(0)
+		for i := 0; i < 2*channels; i++ {
+			b.WriteChanFloat(x)
+		}
+		return b
+	}
+	type input struct {
+		buf       *Buffer
+		gain, pan float64
+	}
+	cases := []struct {
+		name     string
+		policy   OverflowPolicy
+		inputs   []input
+		expected [2]float64
+	}{
+		{"centered mono", Clip, []input{{level(1, 1), 1, 0}}, [2]float64{math.Sqrt2 / 2, math.Sqrt2 / 2}},
+		{"left mono", Clip, []input{{level(1, 1), 0.5, -1}}, [2]float64{0.5, 0}},
+		{"balanced stereo", Clip, []input{{level(2, 0.5), 1, 0.5}}, [2]float64{0.25, 0.5}},
+		{"clip", Clip, []input{{level(2, 0.75), 1, 0}, {level(2, -0.5), 1, 1}}, [2]float64{0.75, 0.25}},
+		{"clip overflow", Clip, []input{{level(2, 0.75), 1, 0}, {level(2, 0.5), 1, 0}}, [2]float64{1, 1}},
+		{"soft clip quiet", SoftClip, []input{{level(2, 0.25), 1, 0}, {level(2, 0.25), 1, 0}}, [2]float64{0.5, 0.5}},
+		{"soft clip", SoftClip, []input{{level(2, 1), 1, 0}, {level(2, 1), 1, 0}}, [2]float64{0.5 + 0.5*math.Tanh(3), 0.5 + 0.5*math.Tanh(3)}},
+		{"normalize", Normalize, []input{{level(2, 1), 1, 0}, {level(2, 1), 1, -1}}, [2]float64{1, 0.5}},
+		{"normalize quiet", Normalize, []input{{level(2, 0.25), 1, 0}}, [2]float64{0.25, 0.25}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			m := NewMixer(New(1000, 3, 2), c.policy)
+			for _, in := range c.inputs {
+				if err := m.Add(in.buf, time.Millisecond, in.gain, in.pan); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out, err := m.Mix()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, expected := out.SampleLen(), 3; got != expected {
+				t.Fatalf("%d (got) != %d (expected) samples", got, expected)
+			}
+			for ch, expected := range c.expected {
+				if got := out.ReadFloat(0, ch); got != 0 {
+					t.Errorf("channel %d before offset: %v (got) != 0 (expected)", ch, got)
+				}
+				if got := out.ReadFloat(2, ch); math.Abs(got-expected) > 1e-6 {
+					t.Errorf("channel %d: %v (got) != %v (expected)", ch, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func Test_Envelope(t *testing.T) {
+	env := Envelope{
+		Delay:   2 * time.Millisecond,
+		Attack:  4 * time.Millisecond,
+		Hold:    2 * time.Millisecond,
+		Decay:   4 * time.Millisecond,
+		Sustain: 0.5,
+		Release: 4 * time.Millisecond,
+	}
+	// At 1 kHz, there is one sample per millisecond.
+	linear := []float64{
+		0, 0, // delay
+		0, 0.25, 0.5, 0.75, // attack
+		1, 1, // hold
+		1, 0.875, 0.75, 0.625, // decay
+		0.5, 0.5, // sustain
+		0.5, 0.375, 0.25, 0.125, // release
+		0, 0,
+	}
+	g := env.Generator(1000)
+	for i, expected := range linear {
+		if i == 14 {
+			g.NoteOff()
+		}
+		if got := g.Next(); math.Abs(got-expected) > 1e-9 {
+			t.Errorf("sample %d: %v (got) != %v (expected)", i, got, expected)
+		}
+	}
+	if !g.Done() {
+		t.Error("expected the envelope to be done")
+	}
+
+	t.Run("release during attack", func(t *testing.T) {
+		g := Envelope{Attack: 4 * time.Millisecond, Release: 2 * time.Millisecond}.Generator(1000)
+		for i, expected := range []float64{0, 0.25, 0.5, 0.5, 0.25, 0} {
+			if i == 3 {
+				g.NoteOff()
+			}
+			if got := g.Next(); got != expected {
+				t.Errorf("sample %d: %v (got) != %v (expected)", i, got, expected)
+			}
+		}
+	})
+
+	t.Run("exponential", func(t *testing.T) {
+		env := env
+		env.Curve = Exponential
+		g := env.Generator(1000)
+		levels := make([]float64, len(linear))
+		for i := range levels {
+			if i == 14 {
+				g.NoteOff()
+			}
+			levels[i] = g.Next()
+		}
+		// Each stage still starts at the end of the previous one, but moves
+		// faster than the linear curve early on.
+		for _, i := range []int{0, 2, 6, 8, 12, 14, 18} {
+			if got, expected := levels[i], linear[i]; math.Abs(got-expected) > 1e-9 {
+				t.Errorf("sample %d: %v (got) != %v (expected)", i, got, expected)
+			}
+		}
+		if got, limit := levels[3], linear[3]; got <= limit {
+			t.Errorf("attack: %v (got) <= %v (linear)", got, limit)
+		}
+		if got, limit := levels[15], linear[15]; got >= limit {
+			t.Errorf("release: %v (got) >= %v (linear)", got, limit)
+		}
+	})
+
+	t.Run("buffer", func(t *testing.T) {
+		enc := New(1000, 2, 1)
+		b, err := enc.NewSilence(20 * time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < b.SampleLen(); i++ {
+			b.WriteValue(1000, i, 0)
+		}
+		b.ApplyEnvelope(env, 14*time.Millisecond)
+		for i, level := range linear {
+			if got, expected := b.ReadValue(i, 0), int(1000*level); got != expected {
+				t.Errorf("sample %d: %d (got) != %d (expected)", i, got, expected)
+			}
+		}
+	})
+}
+
+// power returns the power spectrum of the first channel of b, which must
+// hold a power of two samples.
+func power(b *Buffer) []float64 {
+	n := b.SampleLen()
+	re, im := make([]float64, n), make([]float64, n)
+	for i := range re {
+		re[i] = b.ReadFloat(i, 0)
+	}
+	// Iterative radix-2 FFT.
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			re[i], re[j] = re[j], re[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := -2 * math.Pi / float64(size)
+		for start := 0; start < n; start += size {
+			for k := 0; k < size/2; k++ {
+				wr, wi := math.Cos(step*float64(k)), math.Sin(step*float64(k))
+				a, b := start+k, start+k+size/2
+				tr := wr*re[b] - wi*im[b]
+				ti := wr*im[b] + wi*re[b]
+				re[b], im[b] = re[a]-tr, im[a]-ti
+				re[a], im[a] = re[a]+tr, im[a]+ti
+			}
+		}
+	}
+	p := make([]float64, n/2+1)
+	for k := range p {
+		p[k] = re[k]*re[k] + im[k]*im[k]
+	}
+	return p
+}
+
+// aliasing returns the power outside of the harmonics of the bin, relative
+// to the power of the harmonics, in decibels.
+func aliasing(p []float64, bin int) float64 {
+	var harmonics, aliases float64
+	for k, x := range p {
+		if k%bin == 0 {
+			harmonics += x
+		} else {
+			aliases += x
+		}
+	}
+	return 10 * math.Log10(aliases/harmonics)
+}
+
+func Test_BandLimited(t *testing.T) {
+	const (
+		samples = 1 << 14
+		// bin is how many cycles fit in the samples. It is prime, so that
+		// aliases fall between the harmonics.
+		bin = 1069
+	)
+	naive := NewFloat(48000, 8, 1)
+	smooth := NewFloat(48000, 8, 1)
+	smooth.BandLimited = true
+	frequency := float64(bin) * 48000 / samples // about 3.1 kHz
+	duration := time.Duration(samples) * time.Second / 48000
+
+	generators := []struct {
+		name string
+		gen  func(enc *Encoder) (*Buffer, error)
+		// limit is the most aliasing allowed when band-limited, in decibels.
+		limit float64
+	}{
+		{"square", func(enc *Encoder) (*Buffer, error) {
+			return enc.Square(duration, frequency, 0.5, 0)
+		}, -28},
+		{"pulse", func(enc *Encoder) (*Buffer, error) {
+			return enc.Pulse(duration, frequency, 0.5, 0, 0.25)
+		}, -28},
+		{"sawtooth", func(enc *Encoder) (*Buffer, error) {
+			return enc.Sawtooth(duration, frequency, 0.5, 0)
+		}, -24},
+		{"triangle", func(enc *Encoder) (*Buffer, error) {
+			return enc.Triangle(duration, frequency, 0.5, 0)
+		}, -45},
+	}
+	for _, g := range generators {
+		g := g
+		t.Run(g.name, func(t *testing.T) {
+			a, err := g.gen(naive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := g.gen(smooth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.SampleLen() != samples || b.SampleLen() != samples {
+				t.Fatalf("%d, %d (got) != %d (expected) samples", a.SampleLen(), b.SampleLen(), samples)
+			}
+			pa, pb := power(a), power(b)
+			before, after := aliasing(pa, bin), aliasing(pb, bin)
+			t.Logf("aliasing: %.1f dB naive, %.1f dB band-limited", before, after)
+			if after > g.limit || after > before-10 {
+				t.Errorf("%.1f dB (got) > %.1f dB (expected)", after, math.Min(g.limit, before-10))
+			}
+			// The fundamental is left alone.
+			if ratio := 10 * math.Log10(pb[bin]/pa[bin]); math.Abs(ratio) > 0.5 {
+				t.Errorf("fundamental changed by %.2f dB", ratio)
+			}
+		})
+	}
+}
+
+func Test_Oscillator(t *testing.T) {
+	enc := New(48000, 2, 2)
+	const samples = 4800
+
+	t.Run("matches generators", func(t *testing.T) {
+		buf, err := enc.Sine(100*time.Millisecond, 440, 0.5, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := enc.NewOscillator(SineWave, 440, 0.5, 1)
+		block := make([]float64, samples*enc.Channels)
+		o.Process(block)
+		for i := 0; i < samples; i++ {
+			for c := 0; c < enc.Channels; c++ {
+				if got, expected := block[i*enc.Channels+c], buf.ReadFloat(i, c); math.Abs(got-expected) > 1e-4 {
+					t.Fatalf("sample %d: %v (got) != %v (expected)", i, got, expected)
+				}
+			}
+		}
+	})
+
+	t.Run("reads", func(t *testing.T) {
+		// Odd-sized reads, even splitting samples, give the same stream.
+		whole := make([]byte, samples*4)
+		if _, err := io.ReadFull(enc.NewOscillator(TriangleWave, 1000, 0.5, 0), whole); err != nil {
+			t.Fatal(err)
+		}
+		var pieces bytes.Buffer
+		o := enc.NewOscillator(TriangleWave, 1000, 0.5, 0)
+		p := make([]byte, 4097)
+		for size := 1; pieces.Len() < len(whole); size = size*3 + 1 {
+			n, err := o.Read(p[:min(size%len(p), len(whole)-pieces.Len())])
+			if err != nil {
+				t.Fatal(err)
+			}
+			pieces.Write(p[:n])
+		}
+		if !bytes.Equal(pieces.Bytes(), whole) {
+			t.Error("streamed samples differ")
+		}
+	})
+
+	t.Run("retune", func(t *testing.T) {
+		o := enc.NewOscillator(SawtoothWave, 100, 1, 0)
+		o.Amplitude = 0.5
+		block := make([]float64, 480*enc.Channels)
+		o.Process(block)
+		if got := block[len(block)-1]; math.Abs(got) > 0.5 {
+			t.Errorf("%v (got) beyond the new amplitude", got)
+		}
+
+		// Raising the frequency keeps the phase, so the saw keeps rising from
+		// where it was without a jump.
+		o.Frequency = 200
+		last := block[len(block)-1]
+		o.Process(block)
+		if step := block[0] - last; step <= 0 || step > 0.01 {
+			t.Errorf("%v (got) step at the retune", step)
+		}
+	})
+}
+
+func Test_PulseWidthModulation(t *testing.T) {
+	enc := NewFloat(48000, 8, 1)
+	// dutyCycles returns the fraction of each 100-sample period that is high.
+	dutyCycles := func(b *Buffer) []float64 {
+		var duties []float64
+		for start := 0; start+100 <= b.SampleLen(); start += 100 {
+			high := 0
+			for i := start; i < start+100; i++ {
+				if b.ReadFloat(i, 0) > 0 {
+					high++
+				}
+			}
+			duties = append(duties, float64(high)/100)
+		}
+		return duties
+	}
+
+	o := enc.NewOscillator(PulseWave, 480, 0.5, 0)
+	o.Duty = 0.25
+	b, err := enc.Generate(o, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := b.SampleLen(), 4800; got != expected {
+		t.Fatalf("%d (got) != %d (expected) samples", got, expected)
+	}
+	for i, duty := range dutyCycles(b) {
+		if duty != 0.25 {
+			t.Fatalf("period %d: %v (got) != 0.25 (expected)", i, duty)
+		}
+	}
+
+	// A square LFO at 48 Hz switches the duty cycle between 0.25 and 0.75
+	// every 5 periods.
+	o = enc.NewOscillator(PulseWave, 480, 0.5, 0)
+	o.DutyModulator = enc.NewOscillator(SquareWave, 48, 0.25, 0)
+	b, err = enc.Generate(o, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, duty := range dutyCycles(b) {
+		expected := 0.75
+		if i/5%2 == 1 {
+			expected = 0.25
+		}
+		if duty != expected {
+			t.Errorf("period %d: %v (got) != %v (expected)", i, duty, expected)
+		}
+	}
+
+	// The duty cycle is kept in bounds, so the pulse doesn't become silent.
+	for _, duty := range []float64{-1, 2} {
+		o := enc.NewOscillator(PulseWave, 48, 0.5, 0)
+		o.Duty = duty
+		block := make([]float64, 1000)
+		o.Process(block)
+		high := 0
+		for _, x := range block {
+			if x > 0 {
+				high++
+			}
+		}
+		if high < 5 || high > 995 {
+			t.Errorf("duty %v: %d (got) high samples of 1000", duty, high)
+		}
+	}
+}
+
+func Test_Noise(t *testing.T) {
+	const samples = 1 << 16
+	enc := NewFloat(48000, 8, 2)
+	d := time.Duration(samples) * time.Second / 48000
+
+	// bandPower returns the average power per bin of the octave from
+	// 375 Hz times 2^octave.
+	bandPower := func(p []float64, octave int) float64 {
+		lo := 512 << octave // 375 Hz
+		sum := 0.0
+		for k := lo; k < 2*lo; k++ {
+			sum += p[k]
+		}
+		return sum / float64(lo)
+	}
+
+	cases := []struct {
+		color NoiseColor
+		// slope is the expected change in dB per octave.
+		slope float64
+		rms   float64
+		// tolerance is the allowed relative error of the RMS level. The
+		// slowest pink rows change only a few times in the test, so its level
+		// varies the most.
+		tolerance float64
+	}{
+		{White, 0, 0.8 / math.Sqrt(3), 0.1},
+		{Gaussian, 0, 0.2, 0.1},
+		{Pink, -3, 0.2, 0.3},
+		{Brown, -6, 0.2, 0.1},
+		{Blue, 3, 0.2, 0.1},
+		{Violet, 6, 0.2, 0.1},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(fmt.Sprintf("color=%d", c.color), func(t *testing.T) {
+			b, err := enc.ColoredNoise(d, c.color, 0.8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.SampleLen(); got != samples {
+				t.Fatalf("%d (got) != %d (expected) samples", got, samples)
+			}
+
+			var sum, dx2, dy2, dxy float64
+			for i := 1; i < samples; i++ {
+				x, y := b.ReadFloat(i, 0), b.ReadFloat(i, 1)
+				if math.Abs(x) > 0.8 {
+					t.Fatalf("sample %d: %v (got) beyond the amplitude", i, x)
+				}
+				sum += x * x
+				// Correlate the changes, which are not dominated by the few
+				// independent values of the low frequencies.
+				dx, dy := x-b.ReadFloat(i-1, 0), y-b.ReadFloat(i-1, 1)
+				dx2, dy2, dxy = dx2+dx*dx, dy2+dy*dy, dxy+dx*dy
+			}
+			if rms := math.Sqrt(sum / samples); math.Abs(rms-c.rms) > c.tolerance*c.rms {
+				t.Errorf("%.3f (got) != %.3f (expected) RMS", rms, c.rms)
+			}
+			if corr := dxy / math.Sqrt(dx2*dy2); math.Abs(corr) > 0.05 {
+				t.Errorf("%.3f (got) correlation between independent channels", corr)
+			}
+
+			// Measure the slope from 375 Hz to 6 kHz, below where the
+			// differences and random walks bend towards Nyquist.
+			p := power(b)
+			slope := 10 * math.Log10(bandPower(p, 3)/bandPower(p, 0)) / 3
+			if math.Abs(slope-c.slope) > 1 {
+				t.Errorf("%.2f (got) != %.0f (expected) dB per octave", slope, c.slope)
+			}
+		})
+	}
+
+	t.Run("correlated", func(t *testing.T) {
+		n := enc.NewNoise(Pink, 0.5)
+		n.Correlated = true
+		block := make([]float64, 1000)
+		n.Process(block)
+		for i := 0; i < len(block); i += 2 {
+			if block[i] != block[i+1] {
+				t.Fatalf("sample %d: %v != %v", i/2, block[i], block[i+1])
+			}
+		}
+	})
+}
+
+func Test_Source(t *testing.T) {
+	// render makes some of every kind of noise, from the given seed.
+	render := func(seed int64) []byte {
+		enc := New(48000, 2, 2)
+		enc.Source = rand.NewSource(seed)
+		var out bytes.Buffer
+		white, err := enc.WhiteNoise(time.Millisecond, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Write(white.Bytes())
+		for color := White; color <= Violet; color++ {
+			b, err := enc.ColoredNoise(time.Millisecond, color, 0.5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out.Write(b.Bytes())
+		}
+		return out.Bytes()
+	}
+
+	a, b := render(1), render(1)
+	if !bytes.Equal(a, b) {
+		t.Error("renders with the same seed differ")
+	}
+	if bytes.Equal(a, render(2)) {
+		t.Error("renders with different seeds are identical")
+	}
+	// The first samples are fixed across runs and machines.
+	expected := []byte{0x65, 0x0d, 0x62, 0x38, 0x10, 0x15, 0x07, 0xf8}
+	if got := a[:len(expected)]; !bytes.Equal(got, expected) {
+		t.Errorf("%#v (got) != %#v (expected)", got, expected)
+	}
+}
+
+// gainAt returns the gain, in decibels, of a Processor for a steady sine at
+// the given frequency, measured on every channel of a float Encoder.
+func gainAt(t *testing.T, enc *Encoder, p Processor, frequency float64) float64 {
+	t.Helper()
+	b, err := enc.Sine(200*time.Millisecond, frequency, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Apply(p)
+	// Skip the first half, while the filter settles.
+	var sum float64
+	half := b.SampleLen() / 2
+	for i := half; i < b.SampleLen(); i++ {
+		for c := 0; c < enc.Channels; c++ {
+			x := b.ReadFloat(i, c)
+			sum += x * x
+		}
+	}
+	rms := math.Sqrt(sum / float64((b.SampleLen()-half)*enc.Channels))
+	return 20 * math.Log10(rms/(0.5/math.Sqrt2))
+}
+
+func Test_Biquad(t *testing.T) {
+	enc := NewFloat(48000, 8, 2)
+	cases := []struct {
+		filter FilterType
+		gain   float64
+		// expected maps test frequencies to gains in decibels.
+		expected map[float64]float64
+	}{
+		{LowPass, 0, map[float64]float64{100: 0, 1000: -3, 10000: -40}},
+		{HighPass, 0, map[float64]float64{100: -40, 1000: -3, 10000: 0}},
+		{BandPass, 0, map[float64]float64{100: -15, 1000: 0, 10000: -15}},
+		{Notch, 0, map[float64]float64{100: 0, 1000: -60, 10000: 0}},
+		{AllPass, 0, map[float64]float64{100: 0, 1000: 0, 10000: 0}},
+		{Peaking, 6, map[float64]float64{100: 0, 1000: 6, 10000: 0}},
+		{Peaking, -6, map[float64]float64{100: 0, 1000: -6, 10000: 0}},
+		{LowShelf, 6, map[float64]float64{50: 6, 1000: 3, 15000: 0}},
+		{HighShelf, -6, map[float64]float64{50: 0, 1000: -3, 15000: -6}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(fmt.Sprintf("type=%d&gain=%v", c.filter, c.gain), func(t *testing.T) {
+			for frequency, expected := range c.expected {
+				f, err := enc.NewBiquad(c.filter, 1000, 1/math.Sqrt2, c.gain)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := gainAt(t, enc, f, frequency)
+				// Stop bands only need to reach the expected depth.
+				if expected <= -15 && got <= expected {
+					continue
+				}
+				if math.Abs(got-expected) > 0.5 {
+					t.Errorf("%v Hz: %.2f dB (got) != %.2f dB (expected)", frequency, got, expected)
+				}
+			}
+		})
+	}
+
+	t.Run("blocks", func(t *testing.T) {
+		// Filtering in blocks matches filtering all at once.
+		saw, err := enc.Sawtooth(50*time.Millisecond, 220, 0.5, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		whole := make([]float64, saw.SampleLen()*enc.Channels)
+		for i := range whole {
+			whole[i] = saw.ReadFloat(i/enc.Channels, i%enc.Channels)
+		}
+		pieces := append([]float64(nil), whole...)
+
+		f, _ := enc.NewBiquad(LowPass, 2000, 2, 0)
+		f.Process(whole)
+		g, _ := enc.NewBiquad(LowPass, 2000, 2, 0)
+		for start := 0; start < len(pieces); start += 98 {
+			g.Process(pieces[start:min(start+98, len(pieces))])
+		}
+		for i := range whole {
+			if whole[i] != pieces[i] {
+				t.Fatalf("sample %d: %v (got) != %v (expected)", i, pieces[i], whole[i])
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, frequency := range []float64{0, 24000} {
+			if _, err := enc.NewBiquad(LowPass, frequency, 1, 0); err == nil {
+				t.Errorf("%v Hz: expected an error", frequency)
+			}
+		}
+		if _, err := enc.NewBiquad(LowPass, 1000, 0, 0); err == nil {
+			t.Error("Q of 0: expected an error")
+		}
+	})
+}
+
+// ringing returns the RMS level of a Processor over the second half of a
+// second, after it is struck by an impulse.
+func ringing(t *testing.T, enc *Encoder, p Processor) float64 {
+	t.Helper()
+	b, err := enc.NewSilence(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.WriteFloat(0.5, 0, 0)
+	b.Apply(p)
+	var sum float64
+	half := b.SampleLen() / 2
+	for i := half; i < b.SampleLen(); i++ {
+		x := b.ReadFloat(i, 0)
+		sum += x * x
+	}
+	return math.Sqrt(sum / float64(b.SampleLen()-half))
+}
+
+func Test_Filters(t *testing.T) {
+	enc := NewFloat(48000, 8, 1)
+	t.Run("svf", func(t *testing.T) {
+		cases := []struct {
+			filter    FilterType
+			frequency float64
+			min, max  float64
+		}{
+			{LowPass, 100, -0.5, 0.5},
+			{LowPass, 8000, -45, -30},
+			{HighPass, 100, -45, -30},
+			{HighPass, 8000, -0.5, 0.5},
+			{BandPass, 1000, -0.5, 0.5},
+			{BandPass, 8000, -25, -15},
+			{Notch, 1000, -math.Inf(1), -20},
+		}
+		for _, c := range cases {
+			f, err := enc.NewSVF(c.filter, 1000, 0.3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := gainAt(t, enc, f, c.frequency); got < c.min || got > c.max {
+				t.Errorf("type %d at %v Hz: %.1f dB (got) not in [%v, %v] (expected)", c.filter, c.frequency, got, c.min, c.max)
+			}
+		}
+		if _, err := enc.NewSVF(AllPass, 1000, 0); err == nil {
+			t.Error("AllPass: expected an error")
+		}
+	})
+
+	t.Run("ladder", func(t *testing.T) {
+		f, err := enc.NewLadder(1000, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The input saturates a little at this level.
+		if got := gainAt(t, enc, f, 100); got < -1 || got > 0 {
+			t.Errorf("pass band: %.1f dB (got) not in [-1, 0] dB (expected)", got)
+		}
+		f, _ = enc.NewLadder(1000, 0)
+		// Four poles fall by 24 dB per octave.
+		if got := gainAt(t, enc, f, 8000); got > -65 {
+			t.Errorf("stop band: %.1f dB (got) > -65 dB (expected)", got)
+		}
+		if _, err := enc.NewLadder(30000, 0); err == nil {
+			t.Error("cutoff above Nyquist: expected an error")
+		}
+	})
+
+	t.Run("self-oscillation", func(t *testing.T) {
+		cases := []struct {
+			resonance   float64
+			oscillating bool
+		}{
+			{0.5, false},
+			{0.9, false},
+			{1.1, true},
+			{2, true},
+		}
+		for _, c := range cases {
+			f, err := enc.NewLadder(1000, c.resonance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			level := ringing(t, enc, f)
+			if got := level > 0.01; got != c.oscillating {
+				t.Errorf("resonance %v: level %v: %v (got) != %v (expected)", c.resonance, level, got, c.oscillating)
+			}
+			if level > 1 {
+				t.Errorf("resonance %v: level %v (got) > 1 (expected)", c.resonance, level)
+			}
+		}
+	})
+
+	t.Run("modulation", func(t *testing.T) {
+		// An LFO sweeps the cutoff from 250 Hz to 4 kHz, so that a 2 kHz
+		// sine is let through only while the cutoff is high.
+		f, err := enc.NewSVF(LowPass, 1000, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.CutoffModulator = enc.NewOscillator(SquareWave, 2, 1, 0)
+		f.CutoffDepth = 2
+		b, err := enc.Sine(time.Second, 2000, 0.5, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Apply(f)
+		peak := func(from, to time.Duration) float64 {
+			var p float64
+			for i := enc.SamplesForDuration(from); i < enc.SamplesForDuration(to); i++ {
+				p = math.Max(p, math.Abs(b.ReadFloat(i, 0)))
+			}
+			return p
+		}
+		// The square LFO is high for the first quarter second.
+		if open, shut := peak(50*time.Millisecond, 200*time.Millisecond), peak(300*time.Millisecond, 450*time.Millisecond); open < 0.3 || shut > 0.1 {
+			t.Errorf("peaks %.2f and %.2f (got) not above 0.3 and below 0.1 (expected)", open, shut)
+		}
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		// A sawtooth through a low-pass filter is smoother than one without.
+		roughness := func(p Processor) float64 {
+			b, err := enc.Generate(p, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sum float64
+			for i := 1; i < b.SampleLen(); i++ {
+				sum += math.Abs(b.ReadFloat(i, 0) - b.ReadFloat(i-1, 0))
+			}
+			return sum
+		}
+		saw := roughness(enc.NewOscillator(SawtoothWave, 220, 0.5, 0))
+		f, err := enc.NewLadder(500, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filtered := roughness(Chain{enc.NewOscillator(SawtoothWave, 220, 0.5, 0), f})
+		if filtered >= saw/2 {
+			t.Errorf("%v (got) >= %v (expected)", filtered, saw/2)
+		}
+	})
+}
+
+func Test_Resample(t *testing.T) {
+	const samples = 1 << 14
+	cd := NewFloat(44100, 8, 1)
+	studio := NewFloat(48000, 8, 1)
+
+	// resampled converts a sine at the frequency to the rate of the
+	// destination, and returns the middle samples, away from the edges.
+	resampled := func(t *testing.T, from, to *Encoder, interp Interpolation, frequency float64) *Buffer {
+		t.Helper()
+		in, err := from.Sine(time.Second, frequency, 0.5, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := in.Resample(to, interp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mid, err := to.NewBuffer(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := (out.SampleLen() - samples) / 2
+		for i := start; i < start+samples; i++ {
+			mid.WriteChanFloat(out.ReadFloat(i, 0))
+		}
+		return mid
+	}
+	// gain returns the level of a sine of amplitude 0.5, in decibels.
+	gain := func(b *Buffer) float64 {
+		var sum float64
+		for i := 0; i < b.SampleLen(); i++ {
+			x := b.ReadFloat(i, 0)
+			sum += x * x
+		}
+		rms := math.Sqrt(sum / float64(b.SampleLen()))
+		return 20 * math.Log10(rms/(0.5/math.Sqrt2))
+	}
+
+	t.Run("length", func(t *testing.T) {
+		in, err := cd.Sine(time.Second, 440, 0.5, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, interp := range []Interpolation{LinearInterpolation, CubicInterpolation, SincInterpolation} {
+			out, err := in.Resample(studio, interp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, expected := out.SampleLen(), 48000; got != expected {
+				t.Errorf("interpolation %d: %v (got) != %v (expected)", interp, got, expected)
+			}
+		}
+	})
+
+	t.Run("passband ripple", func(t *testing.T) {
+		cases := []struct {
+			interp Interpolation
+			// top is the highest frequency tested, and ripple the largest
+			// deviation allowed up to it, in decibels.
+			top, ripple float64
+		}{
+			{LinearInterpolation, 5000, 1},
+			{CubicInterpolation, 5000, 0.2},
+			{SincInterpolation, 19000, 0.05},
+		}
+		for _, c := range cases {
+			for _, pair := range [][2]*Encoder{{cd, studio}, {studio, cd}} {
+				for f := 100.0; f <= c.top; f *= 1.5 {
+					got := gain(resampled(t, pair[0], pair[1], c.interp, f))
+					if math.Abs(got) > c.ripple {
+						t.Errorf("interpolation %d from %d Hz at %.0f Hz: %.3f dB (got) not within %v dB (expected)",
+							c.interp, pair[0].Rate, f, got, c.ripple)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("aliasing rejection", func(t *testing.T) {
+		// Images of a 15 kHz tone upsampled from 44.1 kHz fold back to
+		// 48 - (44.1 - 15) = 18.9 kHz. The tone falls on a bin of the
+		// output, so that every other bin is an image or noise.
+		const bin = 5120
+		frequency := float64(bin) * 48000 / samples
+		for _, c := range []struct {
+			interp Interpolation
+			max    float64
+		}{
+			{LinearInterpolation, -10},
+			{CubicInterpolation, -12},
+			{SincInterpolation, -90},
+		} {
+			got := aliasing(power(resampled(t, cd, studio, c.interp, frequency)), bin)
+			if got > c.max {
+				t.Errorf("interpolation %d: %.1f dB (got) > %v dB (expected)", c.interp, got, c.max)
+
+			}
+		}
+
+		// Downsampling a 23 kHz tone from 48 kHz would alias to 21.1 kHz.
+		// The sinc filters it out.
+		out := resampled(t, studio, cd, SincInterpolation, 23000)
+		if got := gain(out); got > -90 {
+			t.Errorf("downsampled alias: %.1f dB (got) > -90 dB (expected)", got)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		enc := New(44100, 2, 2)
+		in, err := enc.Sawtooth(100*time.Millisecond, 440, 0.5, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to := New(48000, 3, 2)
+		expected, err := in.Resample(to, SincInterpolation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewResampler(bytes.NewReader(in.Bytes()), enc, to, SincInterpolation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Read in odd sizes, to split samples between reads.
+		var got []byte
+		p := make([]byte, 1001)
+		for {
+			n, err := r.Read(p)
+			got = append(got, p[:n]...)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(got, expected.Bytes()) {
+			t.Errorf("%d bytes (got) != %d bytes (expected), or differ", len(got), len(expected.Bytes()))
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b, err := cd.NewSilence(time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Resample(NewFloat(48000, 8, 2), SincInterpolation); err == nil {
+			t.Error("channel mismatch: expected an error")
+		}
+		if _, err := b.Resample(studio, Interpolation(-1)); err == nil {
+			t.Error("unknown interpolation: expected an error")
+		}
+	})
+}
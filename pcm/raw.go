@@ -0,0 +1,111 @@
+package pcm
+
+import "fmt"
+
+// Signedness is how integer samples are stored in raw data.
+type Signedness int
+
+const (
+	// NativeSign matches a Buffer: unsigned for a byte depth of 1, and signed
+	// otherwise.
+	NativeSign Signedness = iota
+	// Signed is two's complement, resting at 0.
+	Signed
+	// Unsigned is offset binary, resting at half of the full scale.
+	Unsigned
+)
+
+// RawLayout describes how audio is arranged in raw data from outside a
+// Buffer. The zero value is the layout of a Buffer itself: little-endian,
+// native signedness, and interleaved.
+type RawLayout struct {
+	BigEndian bool
+	// Sign applies to integer formats only; float and G.711 samples are
+	// stored as they are.
+	Sign Signedness
+	// Planar stores all samples of the first channel, then all samples of
+	// the second channel, and so on, instead of interleaving the channels
+	// of each sample.
+	Planar bool
+}
+
+// FromBytes creates a Buffer holding a copy of raw audio data, arranged as
+// described by layout. The data must hold a whole number of samples.
+func FromBytes(enc *Encoder, data []byte, layout RawLayout) (*Buffer, error) {
+	if err := enc.Validate(); err != nil {
+		return nil, err
+	}
+	frame := enc.Depth * enc.Channels
+	if len(data)%frame != 0 {
+		return nil, fmt.Errorf("%d bytes is not a whole number of %d-byte samples", len(data), frame)
+	}
+	b := &Buffer{
+		encoder: enc,
+		data:    make([]byte, len(data)),
+	}
+	b.rearrange(b.data, data, layout, false)
+	return b, nil
+}
+
+// Export returns a copy of the audio, arranged as described by layout. A
+// trailing partial sample is dropped.
+func (b *Buffer) Export(layout RawLayout) []byte {
+	frame := b.encoder.Depth * b.encoder.Channels
+	data := b.data[:len(b.data)-len(b.data)%frame]
+	out := make([]byte, len(data))
+	b.rearrange(out, data, layout, true)
+	return out
+}
+
+// rearrange copies samples between the layout of the Buffer and the given
+// layout. If export is true, src is in the Buffer's layout; otherwise, dst
+// is.
+func (b *Buffer) rearrange(dst, src []byte, layout RawLayout, export bool) {
+	enc := b.encoder
+	depth := enc.Depth
+	n := len(src) / (depth * enc.Channels)
+	flipSign := b.flipsSign(layout.Sign)
+
+	for i := 0; i < n; i++ {
+		for c := 0; c < enc.Channels; c++ {
+			native := (i*enc.Channels + c) * depth
+			raw := native
+			if layout.Planar {
+				raw = (c*n + i) * depth
+			}
+			from, to := raw, native
+			if export {
+				from, to = native, raw
+			}
+
+			s := dst[to : to+depth]
+			copy(s, src[from:from+depth])
+			if layout.BigEndian {
+				for j, k := 0, depth-1; j < k; j, k = j+1, k-1 {
+					s[j], s[k] = s[k], s[j]
+				}
+			}
+			if flipSign {
+				// The sign bit is in the most significant byte, which comes
+				// first only in exported big-endian samples.
+				msb := depth - 1
+				if export && layout.BigEndian {
+					msb = 0
+				}
+				s[msb] ^= 0x80
+			}
+		}
+	}
+}
+
+// flipsSign reports whether integer samples need their sign bit flipped to
+// convert between the Buffer and the given signedness.
+func (b *Buffer) flipsSign(sign Signedness) bool {
+	switch b.encoder.Format {
+	case FormatInt:
+		return sign == Unsigned
+	case FormatUint8:
+		return sign == Signed
+	}
+	return false
+}
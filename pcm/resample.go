@@ -0,0 +1,345 @@
+package pcm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// Interpolation selects how a resampler computes levels between the samples
+// of its input, trading quality for speed.
+type Interpolation int
+
+const (
+	// LinearInterpolation draws straight lines between samples. It is the
+	// fastest, but dulls high frequencies and lets images and aliases
+	// through.
+	LinearInterpolation Interpolation = iota
+	// CubicInterpolation fits Catmull-Rom splines through every four
+	// samples. It is smoother than linear, but still does not filter out
+	// aliases when downsampling.
+	CubicInterpolation
+	// SincInterpolation convolves with a Kaiser-windowed sinc from a
+	// polyphase table, low-pass filtered below the lower of the two Nyquist
+	// frequencies. It keeps the pass band flat to within a small fraction of
+	// a decibel up to about 90% of that Nyquist frequency, and rejects
+	// aliases by about 90 dB.
+	SincInterpolation
+)
+
+// Windowed sinc parameters. The kernel extends to sincZeros zero crossings on
+// either side, and is tabulated at sincPhases points per zero crossing, with
+// linear interpolation between them. Its cutoff is sincRolloff of the Nyquist
+// frequency, leaving room for the transition band of the window.
+const (
+	sincZeros   = 64
+	sincPhases  = 512
+	sincRolloff = 0.945
+	kaiserBeta  = 9
+)
+
+var (
+	sincOnce  sync.Once
+	sincTable []float64
+)
+
+// sincKernel returns the windowed sinc at u zero crossings from its center,
+// for u from 0 to sincZeros.
+func sincKernel(u float64) float64 {
+	sincOnce.Do(func() {
+		// The final entry is left at zero, for interpolating up to the end.
+		sincTable = make([]float64, sincZeros*sincPhases+2)
+		for k := 0; k <= sincZeros*sincPhases; k++ {
+			u := float64(k) / sincPhases
+			r := u / sincZeros
+			window := besselI0(kaiserBeta*math.Sqrt(1-r*r)) / besselI0(kaiserBeta)
+			sincTable[k] = sincRolloff * sinc(sincRolloff*u) * window
+		}
+	})
+	x := u * sincPhases
+	i := int(x)
+	return sincTable[i] + (x-float64(i))*(sincTable[i+1]-sincTable[i])
+}
+
+// sinc is the normalized sinc function, sin(πx)/(πx).
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind,
+// summed from its power series.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > sum*1e-16; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+// converter changes the rate of interleaved levels. Input frames are written
+// as they arrive, and output frames are read once enough input is available
+// to compute them.
+type converter struct {
+	interp   Interpolation
+	channels int
+	from, to int
+	// scale is the ratio of the output to the input rate, if below 1, so that
+	// the sinc is widened to filter out what the output cannot hold.
+	scale float64
+	// before and after are how many input frames are needed before and
+	// after the position of an output frame.
+	before, after int
+
+	// frames holds the input frames still needed, starting with frame base.
+	frames []float64
+	base   int
+	end    bool
+	// The position of the next output frame, in input frames, is
+	// index + num/to.
+	index, num int
+}
+
+func newConverter(interp Interpolation, channels, from, to int) (*converter, error) {
+	c := &converter{
+		interp:   interp,
+		channels: channels,
+		from:     from,
+		to:       to,
+		scale:    math.Min(1, float64(to)/float64(from)),
+	}
+	switch interp {
+	case LinearInterpolation:
+		c.after = 1
+	case CubicInterpolation:
+		c.before, c.after = 1, 2
+	case SincInterpolation:
+		width := int(math.Ceil(sincZeros / c.scale))
+		c.before, c.after = width-1, width
+	default:
+		return nil, fmt.Errorf("unknown interpolation: %d", interp)
+	}
+	return c, nil
+}
+
+// write appends interleaved input frames.
+func (c *converter) write(frames []float64) {
+	c.frames = append(c.frames, frames...)
+}
+
+// close marks the end of the input, which is followed by silence.
+func (c *converter) close() {
+	c.end = true
+}
+
+// done reports whether every output frame has been read.
+func (c *converter) done() bool {
+	return c.end && c.index >= c.available()
+}
+
+// available returns the index of the frame after the last input frame.
+func (c *converter) available() int {
+	return c.base + len(c.frames)/c.channels
+}
+
+// read appends up to max output frames to out, as many as the input allows.
+func (c *converter) read(out []float64, max int) []float64 {
+	for n := 0; n < max; n++ {
+		if c.end {
+			if c.index >= c.available() {
+				break
+			}
+		} else if c.index+c.after >= c.available() {
+			break
+		}
+		for ch := 0; ch < c.channels; ch++ {
+			out = append(out, c.level(ch))
+		}
+		c.num += c.from
+		c.index += c.num / c.to
+		c.num %= c.to
+	}
+
+	// Drop the frames that are no longer needed.
+	if drop := c.index - c.before - c.base; drop > 0 {
+		drop = min(drop, len(c.frames)/c.channels)
+		c.frames = c.frames[:copy(c.frames, c.frames[drop*c.channels:])]
+		c.base += drop
+	}
+	return out
+}
+
+// at returns the level of an input frame, or silence beyond the input.
+func (c *converter) at(i, ch int) float64 {
+	i -= c.base
+	if i < 0 || i >= len(c.frames)/c.channels {
+		return 0
+	}
+	return c.frames[i*c.channels+ch]
+}
+
+// level computes a channel of the output frame at the current position.
+func (c *converter) level(ch int) float64 {
+	i := c.index
+	t := float64(c.num) / float64(c.to)
+	switch c.interp {
+	case LinearInterpolation:
+		return c.at(i, ch) + t*(c.at(i+1, ch)-c.at(i, ch))
+	case CubicInterpolation:
+		p0, p1, p2, p3 := c.at(i-1, ch), c.at(i, ch), c.at(i+1, ch), c.at(i+2, ch)
+		return p1 + 0.5*t*(p2-p0+t*(2*p0-5*p1+4*p2-p3+t*(3*(p1-p2)+p3-p0)))
+	}
+	var sum float64
+	for j := i - c.before; j <= i+c.after; j++ {
+		u := c.scale * math.Abs(float64(i-j)+t)
+		if u < sincZeros {
+			sum += c.at(j, ch) * sincKernel(u)
+		}
+	}
+	return c.scale * sum
+}
+
+// Resample converts the audio to the rate, depth and format of another
+// Encoder, with the same number of channels, keeping its duration.
+func (b *Buffer) Resample(enc *Encoder, interp Interpolation) (*Buffer, error) {
+	if err := enc.Validate(); err != nil {
+		return nil, err
+	}
+	if enc.Channels != b.encoder.Channels {
+		return nil, fmt.Errorf("cannot resample %d channels into %d", b.encoder.Channels, enc.Channels)
+	}
+	c, err := newConverter(interp, enc.Channels, b.encoder.Rate, enc.Rate)
+	if err != nil {
+		return nil, err
+	}
+	out, err := enc.NewBuffer(b.Duration())
+	if err != nil {
+		return nil, err
+	}
+	if enc.Rate == b.encoder.Rate {
+		for i := 0; i < b.SampleLen(); i++ {
+			for ch := 0; ch < enc.Channels; ch++ {
+				out.WriteChanFloat(b.ReadFloat(i, ch))
+			}
+		}
+		return out, nil
+	}
+
+	frames := make([]float64, 0, b.SampleLen()*enc.Channels)
+	for i := 0; i < b.SampleLen(); i++ {
+		for ch := 0; ch < enc.Channels; ch++ {
+			frames = append(frames, b.ReadFloat(i, ch))
+		}
+	}
+	c.write(frames)
+	c.close()
+	for _, x := range c.read(nil, math.MaxInt) {
+		out.WriteChanFloat(x)
+	}
+	return out, nil
+}
+
+// Resampler converts a stream of encoded audio to the rate, depth and format
+// of another Encoder, with the same number of channels, as it is read.
+type Resampler struct {
+	src      io.Reader
+	from, to *Encoder
+	conv     *converter
+
+	// raw holds input bytes, up to a whole number of samples.
+	raw   []byte
+	block []float64
+	// pending holds encoded bytes that did not fit in the last read.
+	pending []byte
+}
+
+var _ io.Reader = (*Resampler)(nil)
+
+// NewResampler creates a Resampler reading audio in the from encoding, and
+// producing audio in the to encoding.
+func NewResampler(src io.Reader, from, to *Encoder, interp Interpolation) (*Resampler, error) {
+	if err := from.Validate(); err != nil {
+		return nil, err
+	}
+	if err := to.Validate(); err != nil {
+		return nil, err
+	}
+	if from.Channels != to.Channels {
+		return nil, fmt.Errorf("cannot resample %d channels into %d", from.Channels, to.Channels)
+	}
+	c, err := newConverter(interp, from.Channels, from.Rate, to.Rate)
+	if err != nil {
+		return nil, err
+	}
+	return &Resampler{src: src, from: from, to: to, conv: c}, nil
+}
+
+// Read fills p with resampled audio. It returns io.EOF once the source is
+// exhausted and the last of its audio has been read.
+func (r *Resampler) Read(p []byte) (int, error) {
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	p = p[n:]
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	frame := r.to.Depth * r.to.Channels
+	samples := min(max(len(p)/frame, 1), blockSamples)
+	for {
+		r.block = r.conv.read(r.block[:0], samples)
+		if len(r.block) > 0 {
+			break
+		}
+		if r.conv.done() {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return n, err
+		}
+	}
+
+	buf, err := r.to.NewBuffer(0)
+	if err != nil {
+		return n, err
+	}
+	for _, x := range r.block {
+		buf.WriteChanFloat(x)
+	}
+	m := copy(p, buf.Bytes())
+	r.pending = buf.Bytes()[m:]
+	return n + m, nil
+}
+
+// fill reads the next block of input from the source.
+func (r *Resampler) fill() error {
+	frame := r.from.Depth * r.from.Channels
+	held := len(r.raw)
+	r.raw = append(r.raw, make([]byte, blockSamples*frame)...)
+	m, err := r.src.Read(r.raw[held:])
+	r.raw = r.raw[:held+m]
+	if err == io.EOF {
+		r.conv.close()
+	} else if err != nil {
+		return err
+	}
+
+	whole := len(r.raw) - len(r.raw)%frame
+	in := &Buffer{encoder: r.from, data: r.raw[:whole]}
+	frames := make([]float64, 0, in.SampleLen()*r.from.Channels)
+	for i := 0; i < in.SampleLen(); i++ {
+		for ch := 0; ch < r.from.Channels; ch++ {
+			frames = append(frames, in.ReadFloat(i, ch))
+		}
+	}
+	r.conv.write(frames)
+	r.raw = r.raw[:copy(r.raw, r.raw[whole:])]
+	return nil
+}
@@ -0,0 +1,124 @@
+package pcm
+
+import (
+	"io"
+	"time"
+)
+
+// blockSamples is how many samples a Reader asks its Processor for at a time.
+const blockSamples = 512
+
+// Processor generates or transforms audio a block at a time, keeping its
+// state, such as the phase of an oscillator, from one block to the next.
+// Blocks hold interleaved levels from -1 to 1, one per channel of each
+// sample. Generators overwrite the block; effects transform it in place.
+type Processor interface {
+	Process(block []float64)
+}
+
+// Reader encodes the audio of a Processor as an endless stream, for example
+// for synth.Play.
+type Reader struct {
+	enc  *Encoder
+	proc Processor
+
+	block []float64
+	// pending holds encoded bytes that did not fit in the last read.
+	pending []byte
+}
+
+var _ io.Reader = (*Reader)(nil)
+
+// NewReader creates a Reader which encodes the blocks of p.
+func (enc *Encoder) NewReader(p Processor) *Reader {
+	return &Reader{enc: enc, proc: p}
+}
+
+// Read fills p with encoded samples. It never returns io.EOF.
+func (r *Reader) Read(p []byte) (int, error) {
+	if err := r.enc.Validate(); err != nil {
+		return 0, err
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	p = p[n:]
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	frame := r.enc.Depth * r.enc.Channels
+	samples := min(max(len(p)/frame, 1), blockSamples)
+	if cap(r.block) < samples*r.enc.Channels {
+		r.block = make([]float64, blockSamples*r.enc.Channels)
+	}
+	block := r.block[:samples*r.enc.Channels]
+	for i := range block {
+		block[i] = 0
+	}
+	r.proc.Process(block)
+
+	buf, err := r.enc.NewBuffer(0)
+	if err != nil {
+		return n, err
+	}
+	for _, x := range block {
+		buf.WriteChanFloat(x)
+	}
+	m := copy(p, buf.Bytes())
+	r.pending = buf.Bytes()[m:]
+	return n + m, nil
+}
+
+// Generate fills a Buffer with the given duration of audio from a Processor.
+func (enc *Encoder) Generate(p Processor, d time.Duration) (*Buffer, error) {
+	buf, err := enc.NewBuffer(d)
+	if err != nil {
+		return nil, err
+	}
+	block := make([]float64, blockSamples*enc.Channels)
+	for remaining := enc.SamplesForDuration(d); remaining > 0; remaining -= blockSamples {
+		b := block[:min(remaining, blockSamples)*enc.Channels]
+		for i := range b {
+			b[i] = 0
+		}
+		p.Process(b)
+		for _, x := range b {
+			buf.WriteChanFloat(x)
+		}
+	}
+	return buf, nil
+}
+
+// Apply runs the audio of the Buffer through a Processor, such as a filter,
+// in place.
+func (b *Buffer) Apply(p Processor) {
+	channels := b.encoder.Channels
+	block := make([]float64, blockSamples*channels)
+	for start := 0; start < b.SampleLen(); start += blockSamples {
+		n := min(blockSamples, b.SampleLen()-start)
+		blk := block[:n*channels]
+		for i := 0; i < n; i++ {
+			for c := 0; c < channels; c++ {
+				blk[i*channels+c] = b.ReadFloat(start+i, c)
+			}
+		}
+		p.Process(blk)
+		for i := 0; i < n; i++ {
+			for c := 0; c < channels; c++ {
+				b.WriteFloat(blk[i*channels+c], start+i, c)
+			}
+		}
+	}
+}
+
+// Chain is a Processor which runs each of its Processors over the block in
+// turn, such as an Oscillator followed by filters, so that they may be
+// streamed together with NewReader.
+type Chain []Processor
+
+// Process runs the block through each Processor of the Chain.
+func (ch Chain) Process(block []float64) {
+	for _, p := range ch {
+		p.Process(block)
+	}
+}
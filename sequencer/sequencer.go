@@ -0,0 +1,189 @@
+// Package sequencer schedules sounds on a timeline and mixes them, so that
+// they may overlap.
+package sequencer
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+// blockSamples is how many samples the stream mixes at a time.
+const blockSamples = 1024
+
+var errNoTempo = errors.New("scheduling by beat requires a positive tempo")
+
+// Sequencer places sounds at absolute times or beats, and mixes them
+// together when played. Overlapping sounds are summed, and the sum is clipped
+// to the range of the Encoder.
+type Sequencer struct {
+	Encoder *pcm.Encoder
+	// Tempo is the number of beats per minute, used by AddBeat.
+	Tempo float64
+
+	events []event
+}
+
+// event is a Sound scheduled to start at a sample offset.
+type event struct {
+	start int
+	sound Sound
+}
+
+// New creates an empty Sequencer that renders audio in the given encoding.
+func New(enc *pcm.Encoder, tempo float64) *Sequencer {
+	return &Sequencer{Encoder: enc, Tempo: tempo}
+}
+
+// Add schedules a Sound to start at the given time.
+func (s *Sequencer) Add(at time.Duration, sound Sound) error {
+	if at < 0 {
+		return errors.New("negative start time")
+	}
+	s.events = append(s.events, event{
+		start: s.Encoder.SamplesForDuration(at),
+		sound: sound,
+	})
+	return nil
+}
+
+// AddBeat schedules a Sound to start on the given beat, counting from beat 0.
+// Fractional beats are allowed.
+func (s *Sequencer) AddBeat(beat float64, sound Sound) error {
+	at, err := s.BeatTime(beat)
+	if err != nil {
+		return err
+	}
+	return s.Add(at, sound)
+}
+
+// BeatTime returns the time of a beat at the Sequencer's tempo.
+func (s *Sequencer) BeatTime(beat float64) (time.Duration, error) {
+	if s.Tempo <= 0 {
+		return 0, errNoTempo
+	}
+	return time.Duration(beat * 60 / s.Tempo * float64(time.Second)), nil
+}
+
+// Render mixes the whole timeline into a Buffer.
+func (s *Sequencer) Render() (*pcm.Buffer, error) {
+	buf, err := s.Encoder.NewBuffer(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(buf, s.Reader()); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Reader streams the mixed timeline, for example to synth.Play. Sounds are
+// rendered as the stream reaches them, and released once they have played.
+// Sounds added after Reader is called are not included.
+func (s *Sequencer) Reader() io.Reader {
+	events := append([]event(nil), s.events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].start < events[j].start
+	})
+	return &reader{
+		enc:    s.Encoder,
+		events: events,
+		mix:    make([]float64, blockSamples*s.Encoder.Channels),
+	}
+}
+
+// reader mixes a timeline a block at a time.
+type reader struct {
+	enc    *pcm.Encoder
+	events []event
+	// pos is the sample offset of the next block.
+	pos    int
+	voices []voice
+	mix    []float64
+	// pending holds encoded samples not yet read.
+	pending []byte
+	err     error
+}
+
+// voice is a rendered Sound that is playing.
+type voice struct {
+	buf   *pcm.Buffer
+	start int
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.block()
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// block mixes the next block of samples into pending. It returns io.EOF
+// after the last sound has finished.
+func (r *reader) block() error {
+	if len(r.events) == 0 && len(r.voices) == 0 {
+		return io.EOF
+	}
+	end := r.pos + blockSamples
+
+	// Start the sounds that begin within this block.
+	for len(r.events) > 0 && r.events[0].start < end {
+		e := r.events[0]
+		r.events = r.events[1:]
+		buf, err := e.sound.Render(r.enc)
+		if err != nil {
+			return err
+		}
+		r.voices = append(r.voices, voice{buf: buf, start: e.start})
+	}
+
+	// Stop at the end of the last sound, rather than at the end of the block.
+	last := r.pos
+	for _, v := range r.voices {
+		if vEnd := v.start + v.buf.SampleLen(); vEnd > last {
+			last = vEnd
+		}
+	}
+	if len(r.events) > 0 || last > end {
+		last = end
+	}
+
+	for i := range r.mix {
+		r.mix[i] = 0
+	}
+	channels := r.enc.Channels
+	playing := r.voices[:0]
+	for _, v := range r.voices {
+		from := max(r.pos, v.start)
+		to := min(last, v.start+v.buf.SampleLen())
+		for i := from; i < to; i++ {
+			for c := 0; c < channels; c++ {
+				r.mix[(i-r.pos)*channels+c] += v.buf.ReadFloat(i-v.start, c)
+			}
+		}
+		if v.start+v.buf.SampleLen() > last {
+			playing = append(playing, v)
+		}
+	}
+	r.voices = playing
+
+	out, err := r.enc.NewBuffer(0)
+	if err != nil {
+		return err
+	}
+	for _, x := range r.mix[:(last-r.pos)*channels] {
+		out.WriteChanFloat(math.Max(-1, math.Min(1, x)))
+	}
+	r.pending = out.Bytes()
+	r.pos = last
+	return nil
+}
@@ -0,0 +1,136 @@
+package sequencer
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+// constant returns a Clip holding a constant level on every channel.
+func constant(enc *pcm.Encoder, samples int, level float64) Clip {
+	buf, _ := enc.NewBuffer(0)
+	for i := 0; i < samples*enc.Channels; i++ {
+		buf.WriteChanFloat(level)
+	}
+	return Clip{buf}
+}
+
+func TestOverlap(t *testing.T) {
+	enc := pcm.NewFloat(1000, 8, 2)
+	s := New(enc, 120)
+	// Beat 1 is at 500 ms, or sample 500.
+	if err := s.Add(0, constant(enc, 3000, 0.25)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddBeat(1, constant(enc, 1000, 0.5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(2600*time.Millisecond, constant(enc, 1000, 0.5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(3200*time.Millisecond, constant(enc, 100, 0.5)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := buf.SampleLen(), 3600; got != expected {
+		t.Fatalf("%d (got) != %d (expected) samples", got, expected)
+	}
+	expected := map[int]float64{
+		0:    0.25,
+		499:  0.25,
+		500:  0.75,
+		1499: 0.75,
+		1500: 0.25,
+		2600: 0.75,
+		2999: 0.75,
+		3000: 0.5,
+		3250: 1,
+		3300: 0.5,
+		3599: 0.5,
+	}
+	for i, level := range expected {
+		for c := 0; c < enc.Channels; c++ {
+			if got := buf.ReadFloat(i, c); got != level {
+				t.Errorf("sample %d channel %d: %v (got) != %v (expected)", i, c, got, level)
+			}
+		}
+	}
+}
+
+func TestClipping(t *testing.T) {
+	enc := pcm.New(8000, 2, 1)
+	s := New(enc, 0)
+	for i := 0; i < 3; i++ {
+		if err := s.Add(0, constant(enc, 10, 0.5)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := buf.ReadValue(5, 0), math.MaxInt16; got != expected {
+		t.Errorf("%d (got) != %d (expected)", got, expected)
+	}
+	if err := s.AddBeat(1, constant(enc, 1, 0)); err != errNoTempo {
+		t.Errorf("%v (got) != %v (expected)", err, errNoTempo)
+	}
+}
+
+func TestStream(t *testing.T) {
+	enc := pcm.New(48000, 2, 2)
+	s := New(enc, 90)
+	enc.Source = rand.NewSource(1)
+	for beat := 0; beat < 8; beat++ {
+		tone := Tone{
+			Waveform:  Waveform(beat % 5),
+			Frequency: 220 * float64(beat+1),
+			Amplitude: 0.2,
+			Duration:  time.Second,
+			Fade:      10 * time.Millisecond,
+		}
+		if err := s.AddBeat(float64(beat)/2, tone); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reading in odd-sized pieces gives the same bytes as rendering at once.
+	var streamed bytes.Buffer
+	r := s.Reader()
+	p := make([]byte, 777)
+	for {
+		n, err := r.Read(p)
+		streamed.Write(p[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reseed, so that the white noise repeats.
+	enc.Source = rand.NewSource(1)
+	buf, err := s.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(streamed.Bytes(), buf.Bytes()) {
+		t.Fatal("streamed bytes differ from rendered bytes")
+	}
+	end, err := s.BeatTime(3.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, expected := buf.Duration(), end+time.Second; got != expected {
+		t.Errorf("%v (got) != %v (expected)", got, expected)
+	}
+}
@@ -0,0 +1,90 @@
+package sequencer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chaimleib/synth/pcm"
+)
+
+// Sound is something a Sequencer can schedule. It is rendered only when the
+// timeline reaches it, so long timelines don't hold all of their audio at
+// once.
+type Sound interface {
+	Render(enc *pcm.Encoder) (*pcm.Buffer, error)
+}
+
+// Clip is a Sound which plays already-rendered audio. Its sample rate and
+// channel count must match the Sequencer's; its depth and format may differ.
+type Clip struct {
+	*pcm.Buffer
+}
+
+// Render returns the clip's audio.
+func (c Clip) Render(enc *pcm.Encoder) (*pcm.Buffer, error) {
+	src := c.Encoder()
+	if src.Rate != enc.Rate || src.Channels != enc.Channels {
+		return nil, fmt.Errorf(
+			"clip has %d channels at %d Hz, but the sequencer has %d channels at %d Hz",
+			src.Channels, src.Rate, enc.Channels, enc.Rate,
+		)
+	}
+	return c.Buffer, nil
+}
+
+// Waveform selects the generator of a Tone.
+type Waveform int
+
+const (
+	Sine Waveform = iota
+	Square
+	Sawtooth
+	Triangle
+	WhiteNoise
+)
+
+// Tone is a Sound played by one of the pcm.Encoder generators.
+type Tone struct {
+	Waveform  Waveform
+	Frequency float64
+	Amplitude float64
+	Duration  time.Duration
+	// Fade is the length of a linear fade in and out, which keeps the tone
+	// from clicking.
+	Fade time.Duration
+}
+
+// Render generates the tone.
+func (t Tone) Render(enc *pcm.Encoder) (*pcm.Buffer, error) {
+	var (
+		buf *pcm.Buffer
+		err error
+	)
+	switch t.Waveform {
+	case Sine:
+		buf, err = enc.Sine(t.Duration, t.Frequency, t.Amplitude, 0)
+	case Square:
+		buf, err = enc.Square(t.Duration, t.Frequency, t.Amplitude, 0)
+	case Sawtooth:
+		buf, err = enc.Sawtooth(t.Duration, t.Frequency, t.Amplitude, 0)
+	case Triangle:
+		buf, err = enc.Triangle(t.Duration, t.Frequency, t.Amplitude, 0)
+	case WhiteNoise:
+		buf, err = enc.WhiteNoise(t.Duration, t.Amplitude)
+	default:
+		return nil, fmt.Errorf("unknown waveform: %d", t.Waveform)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fade := t.Fade
+	if fade > buf.Duration()/2 {
+		fade = buf.Duration() / 2
+	}
+	if fade > 0 {
+		buf.Fadein(fade)
+		buf.Fadeout(fade)
+	}
+	return buf, nil
+}
@@ -1,110 +1,79 @@
 package synth
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/chaimleib/synth/encoding/aiff"
+	"github.com/chaimleib/synth/encoding/au"
+	"github.com/chaimleib/synth/encoding/flac"
 	"github.com/chaimleib/synth/encoding/wav"
 	"github.com/chaimleib/synth/pcm"
+	"github.com/chaimleib/synth/sequencer"
 	"github.com/hajimehoshi/oto"
 )
 
-// ExampleTones generates test audio. The chunkDuration causes
-// silence to be added, if needed, to the end of the audio so that
-// the duration is a multiple of chunkDuration. The resulting number of bytes
-// per chunk is returned as chunkSize.
+// ExampleTones generates test audio: each waveform in turn, twice, with a
+// pause after each tone. The chunkDuration causes silence to be added, if
+// needed, to the end of the audio so that the duration is a multiple of
+// chunkDuration. The resulting number of bytes per chunk is returned as
+// chunkSize.
 func ExampleTones(chunkDuration time.Duration) (r io.Reader, enc *pcm.Encoder, chunkSize int, err error) {
 	const (
 		sampleRate         = 48000
 		channels           = 2
 		byteDepth          = 2
 		duration           = 500 * time.Millisecond
+		pause              = 500 * time.Millisecond
 		frequency  float64 = 440.0
 		amplitude  float64 = 0.3
-		phase      float64 = 0
-		decay              = 250 * time.Millisecond
-		fadeout            = 20 * time.Millisecond
+		release            = 20 * time.Millisecond
 	)
-
-	enc = pcm.New(sampleRate, byteDepth, channels)
-	square, err := enc.Square(duration, frequency, amplitude, phase)
-	if err != nil {
-		return nil, nil, 0, err
-	}
-	square.Fadein(fadeout)
-	square.Decay(0, decay)
-	square.Fadeout(fadeout)
-
-	sawtooth, err := enc.Sawtooth(duration, frequency, amplitude, phase)
-	if err != nil {
-		return nil, nil, 0, err
+	envelope := pcm.Envelope{
+		Attack:  20 * time.Millisecond,
+		Decay:   250 * time.Millisecond,
+		Sustain: 0.25,
+		Release: release,
+		Curve:   pcm.Exponential,
 	}
-	sawtooth.Fadein(fadeout)
-	sawtooth.Decay(0, decay)
-	sawtooth.Fadeout(fadeout)
-
-	triangle, err := enc.Triangle(duration, frequency, amplitude, phase)
-	if err != nil {
-		return nil, nil, 0, err
+	waveforms := []struct {
+		name     string
+		waveform sequencer.Waveform
+	}{
+		{"square", sequencer.Square},
+		{"sawtooth", sequencer.Sawtooth},
+		{"triangle", sequencer.Triangle},
+		{"sine", sequencer.Sine},
+		{"white noise", sequencer.WhiteNoise},
 	}
-	triangle.Fadein(fadeout)
-	triangle.Decay(0, decay)
-	triangle.Fadeout(fadeout)
 
-	sine, err := enc.Sine(duration, frequency, amplitude, phase)
-	if err != nil {
-		return nil, nil, 0, err
-	}
-	sine.Fadein(fadeout)
-	sine.Decay(0, decay)
-	sine.Fadeout(fadeout)
-
-	noise, err := enc.WhiteNoise(duration, amplitude)
-	if err != nil {
-		return nil, nil, 0, err
-	}
-	noise.Fadein(fadeout)
-	noise.Decay(0, decay)
-	noise.Fadeout(fadeout)
-
-	silence, err := enc.NewSilence(duration)
-	if err != nil {
-		return nil, nil, 0, err
-	}
-
-	sequence := []io.Reader{
-		&printReader{name: "square", buf: square.Bytes()},
-		bytes.NewReader(silence.Bytes()),
-
-		&printReader{name: "sawtooth", buf: sawtooth.Bytes()},
-		bytes.NewReader(silence.Bytes()),
-
-		&printReader{name: "triangle", buf: triangle.Bytes()},
-		bytes.NewReader(silence.Bytes()),
-
-		&printReader{name: "sine", buf: sine.Bytes()},
-		bytes.NewReader(silence.Bytes()),
-
-		&printReader{name: "white noise", buf: noise.Bytes()},
-		bytes.NewReader(silence.Bytes()),
-
-		&printReader{name: "square", buf: square.Bytes()},
-		bytes.NewReader(silence.Bytes()),
-
-		&printReader{name: "sawtooth", buf: sawtooth.Bytes()},
-		bytes.NewReader(silence.Bytes()),
-
-		&printReader{name: "triangle", buf: triangle.Bytes()},
-		bytes.NewReader(silence.Bytes()),
-
-		&printReader{name: "sine", buf: sine.Bytes()},
-		bytes.NewReader(silence.Bytes()),
-
-		&printReader{name: "white noise", buf: noise.Bytes()},
+	enc = pcm.New(sampleRate, byteDepth, channels)
+	seq := sequencer.New(enc, 0)
+	var at time.Duration
+	for i := 0; i < 2; i++ {
+		for _, w := range waveforms {
+			tone := exampleTone{
+				name: w.name,
+				Tone: sequencer.Tone{
+					Waveform:  w.waveform,
+					Frequency: frequency,
+					Amplitude: amplitude,
+					Duration:  duration,
+				},
+				envelope: envelope,
+			}
+			if err := seq.Add(at, tone); err != nil {
+				return nil, nil, 0, err
+			}
+			at += duration + pause
+		}
 	}
+	// The last tone isn't followed by a pause.
+	at -= pause
 
 	if chunkDuration != 0 {
 		// On playback, adding silence at the end avoids premature cutoff.
@@ -112,13 +81,43 @@ func ExampleTones(chunkDuration time.Duration) (r io.Reader, enc *pcm.Encoder, c
 		if err != nil {
 			return nil, nil, 0, err
 		}
-		sequence = append(sequence, bytes.NewReader(chunkFinish.Bytes()))
+		if err := seq.Add(at, sequencer.Clip{Buffer: chunkFinish}); err != nil {
+			return nil, nil, 0, err
+		}
 	}
 
-	return io.MultiReader(sequence...), enc, chunkSize, nil
+	return seq.Reader(), enc, chunkSize, nil
+}
+
+// exampleTone is a Tone shaped by an Envelope, which prints its name as the
+// Sequencer starts it.
+type exampleTone struct {
+	name string
+	sequencer.Tone
+	envelope pcm.Envelope
+}
+
+// Render generates the tone and prints its name.
+func (t exampleTone) Render(enc *pcm.Encoder) (*pcm.Buffer, error) {
+	buf, err := t.Tone.Render(enc)
+	if err != nil {
+		return nil, err
+	}
+	buf.ApplyEnvelope(t.envelope, t.Duration-t.envelope.Release)
+	fmt.Println(t.name)
+	return buf, nil
 }
 
 func Play(r io.Reader, enc *pcm.Encoder, chunkSize int) error {
+	if enc.Format.IsFloat() {
+		return fmt.Errorf("playback does not support float samples")
+	}
+	if enc.Format.IsCompanded() {
+		return fmt.Errorf("playback does not support G.711 samples")
+	}
+	if enc.Depth > 2 {
+		return fmt.Errorf("playback supports byte depths up to 2, not %d", enc.Depth)
+	}
 	p, err := monoPlayer(enc, chunkSize)
 	if err != nil {
 		return err
@@ -128,47 +127,71 @@ func Play(r io.Reader, enc *pcm.Encoder, chunkSize int) error {
 	return err
 }
 
+// Save writes the audio from r to a file at fpath. The file format is picked
+// from the extension: .aif and .aiff for AIFF, .aifc for AIFF-C, .au and .snd
+// for Sun/NeXT AU, .flac for FLAC, and WAV otherwise.
 func Save(r io.Reader, enc *pcm.Encoder, fpath string) error {
-	buf, err := wav.NewEncoder(
-		wav.AudioFormatPCM,
-		enc.Channels,
-		enc.Depth,
-		enc.Rate,
-	).Encode(r)
+	return SaveWithMetadata(r, enc, fpath, nil)
+}
+
+// SaveWithMetadata is like Save, but includes the chunks of meta, such as a
+// Broadcast Wave bext description, if it is not nil. Metadata is only
+// supported for WAV.
+func SaveWithMetadata(r io.Reader, enc *pcm.Encoder, fpath string, meta *wav.Metadata) (err error) {
+	f, err := os.Create(fpath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
 
-	err = os.WriteFile(fpath, buf, 0644)
+	w, err := newFileWriter(f, enc, filepath.Ext(fpath), meta)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
 
-type printReader struct {
-	name string
-	buf  []byte
-	i    int
+	return w.Close()
 }
 
-var _ io.Reader = (*printReader)(nil)
+// newFileWriter starts a file of the format matching the extension ext.
+func newFileWriter(f *os.File, enc *pcm.Encoder, ext string, meta *wav.Metadata) (io.WriteCloser, error) {
+	switch ext = strings.ToLower(ext); ext {
+	case ".aif", ".aiff", ".aifc":
+		if meta != nil {
+			return nil, fmt.Errorf("metadata is not supported in %s files", ext)
+		}
+		e := aiff.NewEncoderFor(enc)
+		e.AIFC = ext == ".aifc"
+		return e.NewWriter(f, aiff.UnknownSize)
 
-func (p *printReader) Read(out []byte) (n int, err error) {
-	if p.i == 0 {
-		fmt.Println(p.name)
-	}
-	end := p.i + len(out)
-	if end > len(p.buf) {
-		end = len(p.buf)
-	}
-	n = copy(out, p.buf[p.i:end])
-	p.i += n
-	if p.i >= len(p.buf) {
-		return n, io.EOF
+	case ".au", ".snd":
+		if meta != nil {
+			return nil, fmt.Errorf("metadata is not supported in %s files", ext)
+		}
+		return au.NewEncoderFor(enc).NewWriter(f, au.UnknownSize)
+
+	case ".flac":
+		if meta != nil {
+			return nil, fmt.Errorf("metadata is not supported in %s files", ext)
+		}
+		e, err := flac.NewEncoderFor(enc)
+		if err != nil {
+			return nil, err
+		}
+		return e.NewWriter(f, flac.UnknownSize)
+
+	default:
+		e := wav.NewEncoderFor(enc)
+		e.Metadata = meta
+		return e.NewWriter(f, wav.UnknownSize)
 	}
-	return n, nil
 }
 
 func monoPlayer(enc *pcm.Encoder, chunkSize int) (*oto.Player, error) {